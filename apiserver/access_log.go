@@ -0,0 +1,99 @@
+package apiserver
+
+import (
+	"context"
+	"go-sqs/logging"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are logged with their values replaced.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+type accessLogCtxKey struct{}
+
+// accessLogEntry collects fields known only to inner middleware, which see a
+// copy of the request and cannot hand values back through its context.
+type accessLogEntry struct {
+	userId string
+}
+
+// setAccessLogUser records the authenticated user in the access log entry of
+// the request ctx belongs to, if there is one.
+func setAccessLogUser(ctx context.Context, userId uuid.UUID) {
+	if entry, ok := ctx.Value(accessLogCtxKey{}).(*accessLogEntry); ok {
+		entry.userId = userId.String()
+	}
+}
+
+// NewAccessLogMiddleware logs one line per request once it has been served.
+// It must run inside NewRequestIdMiddleware so the line carries the request
+// id.
+func NewAccessLogMiddleware(logger *slog.Logger, mux *http.ServeMux, trustForwardedFor bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := &accessLogEntry{}
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogCtxKey{}, entry)))
+
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", routePattern(mux, r)),
+				slog.Int("status", recorder.statusCode()),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int("bytes", recorder.size),
+				slog.String("client_ip", clientIP(r, trustForwardedFor)),
+				slog.Any("headers", redactHeaders(r.Header)),
+			}
+			if entry.userId != "" {
+				attrs = append(attrs, slog.String("user_id", entry.userId))
+			}
+			logging.FromContext(r.Context(), logger).Info("http request", attrs...)
+		})
+	}
+}
+
+// clientIP returns the address of the client. Behind a trusted proxy that is
+// the last X-Forwarded-For entry, the one the proxy appended itself.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			headers[name] = redacted
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
@@ -0,0 +1,168 @@
+package apiserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-sqs/logging"
+	"go-sqs/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+type ApiDeadLetter struct {
+	Id           int64             `json:"id"`
+	Queue        string            `json:"queue"`
+	MessageId    string            `json:"message_id"`
+	Body         string            `json:"body"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	ReceiveCount int               `json:"receive_count"`
+	Reason       string            `json:"reason"`
+	CreatedAt    time.Time         `json:"created_at"`
+	RedrivenAt   *time.Time        `json:"redriven_at,omitempty"`
+	DiscardedAt  *time.Time        `json:"discarded_at,omitempty"`
+}
+
+func newApiDeadLetter(deadLetter *store.DeadLetter) ApiDeadLetter {
+	return ApiDeadLetter{
+		Id:           deadLetter.Id,
+		Queue:        deadLetter.Queue,
+		MessageId:    deadLetter.MessageId,
+		Body:         string(deadLetter.Body),
+		Attributes:   deadLetter.Attributes,
+		ReceiveCount: deadLetter.ReceiveCount,
+		Reason:       deadLetter.Reason,
+		CreatedAt:    deadLetter.CreatedAt,
+		RedrivenAt:   deadLetter.RedrivenAt,
+		DiscardedAt:  deadLetter.DiscardedAt,
+	}
+}
+
+func (s *ApiServer) listDeadLettersHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		limit, err := queryInt(r, "limit", defaultDeadLetterLimit)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		deadLetters, err := s.store.DeadLetters.Pending(r.Context(), min(limit, maxDeadLetterLimit), offset)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		apiDeadLetters := make([]ApiDeadLetter, 0, len(deadLetters))
+		for i := range deadLetters {
+			apiDeadLetters = append(apiDeadLetters, newApiDeadLetter(&deadLetters[i]))
+		}
+
+		if err := encode(ApiResponse[[]ApiDeadLetter]{
+			Data: &apiDeadLetters,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) getDeadLetterHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		deadLetter, err := s.store.DeadLetters.ByPrimaryKey(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		apiDeadLetter := newApiDeadLetter(deadLetter)
+		if err := encode(ApiResponse[ApiDeadLetter]{
+			Data: &apiDeadLetter,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) redriveDeadLetterHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		deadLetter, err := s.store.DeadLetters.Redrive(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusConflict, fmt.Errorf("dead letter %d not found or already handled", id))
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		logging.FromContext(r.Context(), s.logger).Info("redrove dead letter", "dead_letter_id", deadLetter.Id, "queue", deadLetter.Queue)
+
+		apiDeadLetter := newApiDeadLetter(deadLetter)
+		if err := encode(ApiResponse[ApiDeadLetter]{
+			Data:    &apiDeadLetter,
+			Message: "dead letter queued for redelivery",
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) discardDeadLetterHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		deadLetter, err := s.store.DeadLetters.Discard(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusConflict, fmt.Errorf("dead letter %d not found or already handled", id))
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		logging.FromContext(r.Context(), s.logger).Info("discarded dead letter", "dead_letter_id", deadLetter.Id, "queue", deadLetter.Queue)
+
+		apiDeadLetter := newApiDeadLetter(deadLetter)
+		if err := encode(ApiResponse[ApiDeadLetter]{
+			Data:    &apiDeadLetter,
+			Message: "dead letter discarded",
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,165 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-sqs/logging"
+	"go-sqs/mailer"
+	"go-sqs/store"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errEmailNotVerified = errors.New("email address is not verified")
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (r VerifyEmailRequest) Validate() error {
+	if r.Token == "" {
+		return ValidationErrors{"token": "is required"}
+	}
+	return nil
+}
+
+// verificationMessage builds the email carrying token. It links to
+// EmailVerificationUrl when one is configured.
+func verificationMessage(verificationUrl string, email string, token string) (mailer.Message, error) {
+	var body strings.Builder
+	body.WriteString("Confirm your email address to finish setting up your account.\n\n")
+	if verificationUrl != "" {
+		link, err := url.Parse(verificationUrl)
+		if err != nil {
+			return mailer.Message{}, fmt.Errorf("invalid email verification url: %w", err)
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		fmt.Fprintf(&body, "Open this link to confirm it:\n\n%s\n\n", link)
+	} else {
+		fmt.Fprintf(&body, "Your verification token is:\n\n%s\n\n", token)
+	}
+	body.WriteString("If you did not sign up, ignore this email.\n")
+
+	return mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body:    body.String(),
+	}, nil
+}
+
+// sendVerificationEmail issues a new verification token for user, revoking
+// earlier ones, and mails it.
+func (s *ApiServer) sendVerificationEmail(ctx context.Context, user *store.User) error {
+	token, err := s.store.EmailVerifications.Create(ctx, user.Id, s.Config.EmailVerificationTtl)
+	if err != nil {
+		return err
+	}
+	msg, err := verificationMessage(s.Config.EmailVerificationUrl, user.Email, token)
+	if err != nil {
+		return err
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+	return nil
+}
+
+// StartVerificationPruning deletes expired verification tokens every interval
+// until ctx is done.
+func StartVerificationPruning(ctx context.Context, verifications *store.EmailVerificationStore, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := verifications.DeleteExpired(ctx); err != nil {
+			logger.Error("failed to delete expired verification tokens", "error", err)
+		}
+	}
+}
+
+func (s *ApiServer) verifyEmailHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		req, err := decode[VerifyEmailRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		user, err := s.store.EmailVerifications.Verify(r.Context(), req.Token)
+		if errors.Is(err, store.ErrInvalidVerificationToken) {
+			return NewErrWithStatus(http.StatusBadRequest, ValidationErrors{"token": "is invalid or expired"})
+		}
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		setAccessLogUser(r.Context(), user.Id)
+
+		if err := encode(ApiResponse[struct{}]{
+			Message: "email address verified",
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// resendVerificationHandler mails a new token to the signed in user. It is
+// authenticated so it cannot be used to probe which emails have accounts.
+func (s *ApiServer) resendVerificationHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, errors.New("user not found in context"))
+		}
+		if user.EmailVerified() {
+			return NewErrWithStatus(http.StatusConflict, ValidationErrors{"email": "is already verified"})
+		}
+
+		if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		if err := encode(ApiResponse[struct{}]{
+			Message: "verification email sent",
+		}, http.StatusAccepted, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// NewVerifiedEmailMiddleware rejects requests from users who have not
+// verified their email address. It must run after NewAuthMiddleware has put
+// the user in the context.
+func NewVerifiedEmailMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !user.EmailVerified() {
+				if err := encode(ApiResponse[struct{}]{
+					Message:   errEmailNotVerified.Error(),
+					RequestId: logging.RequestId(r.Context()),
+				}, http.StatusForbidden, w); err != nil {
+					logging.FromContext(r.Context(), slog.Default()).Error("error encoding response", "error", err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
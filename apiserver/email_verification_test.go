@@ -0,0 +1,39 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"go-sqs/apiserver"
+	"go-sqs/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifiedEmailMiddleware(t *testing.T) {
+	handler := apiserver.NewVerifiedEmailMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	serve := func(user *store.User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reports", nil)
+		if user != nil {
+			req = req.WithContext(apiserver.ContextWithUser(req, user))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, serve(nil).Code)
+
+	rec := serve(&store.User{Email: "jane@example.com"})
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	var body apiserver.ApiResponse[struct{}]
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	require.Equal(t, "email address is not verified", body.Message)
+
+	verifiedAt := time.Now()
+	require.Equal(t, http.StatusCreated, serve(&store.User{Email: "jane@example.com", EmailVerifiedAt: &verifiedAt}).Code)
+}
@@ -2,60 +2,99 @@ package apiserver
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"go-sqs/logging"
+	"go-sqs/objectstore"
 	"go-sqs/reports"
+	"go-sqs/store"
+	"go-sqs/tracing"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("go-sqs/apiserver")
+
 type SignupRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+// Validate checks the shape of the request. The password policy is applied
+// by signupHandler, which holds its configuration.
 func (r SignupRequest) Validate() error {
+	var errs ValidationErrors
 	if r.Email == "" {
-		return errors.New("email is required")
+		errs = errs.add("email", "is required")
+	} else if _, err := NormalizeEmail(r.Email); err != nil {
+		errs = errs.add("email", err.Error())
 	}
 	if r.Password == "" {
-		return errors.New("password is required")
+		errs = errs.add("password", "is required")
 	}
 
+	if errs != nil {
+		return errs
+	}
 	return nil
 }
 
 type ApiResponse[T any] struct {
-	Data    *T     `json:"data"`
-	Message string `json:"message,omitempty"`
+	Data      *T               `json:"data"`
+	Message   string           `json:"message,omitempty"`
+	Errors    ValidationErrors `json:"errors,omitempty"`
+	RequestId string           `json:"request_id,omitempty"`
 }
 
 func (s *ApiServer) signupHandler() http.HandlerFunc {
 	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		// field errors from the request and the password policy are reported together
 		req, err := decode[SignupRequest](r)
-		if err != nil {
+		var fieldErrs ValidationErrors
+		if err != nil && !errors.As(err, &fieldErrs) {
 			return NewErrWithStatus(http.StatusBadRequest, err)
 		}
+		if err := s.passwordPolicy.Check(req.Password); err != nil {
+			fieldErrs = fieldErrs.add("password", err.Error())
+		}
+		if fieldErrs != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fieldErrs)
+		}
+
+		// Validate has rejected addresses that do not normalize
+		email, _ := NormalizeEmail(req.Email)
 
-		existingUser, err := s.store.Users.ByEmail(r.Context(), req.Email)
+		existingUser, err := s.store.Users.ByEmail(r.Context(), email)
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return NewErrWithStatus(http.StatusInternalServerError, err)
 		}
 
 		if existingUser != nil {
-			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("user exists: %v", existingUser))
+			return NewErrWithStatus(http.StatusConflict, ValidationErrors{"email": "is already registered"})
 		}
 
-		if _, err = s.store.Users.CreateUser(r.Context(), req.Email, req.Password); err != nil {
+		user, err := s.store.Users.CreateUser(r.Context(), email, req.Password)
+		if errors.Is(err, store.ErrUserExists) {
+			return NewErrWithStatus(http.StatusConflict, ValidationErrors{"email": "is already registered"})
+		}
+		if err != nil {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
 		}
 
+		// the account exists either way, a failed email can be resent
+		if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+			logging.FromContext(r.Context(), s.logger).Error("failed to send verification email", "error", err)
+		}
+
 		if err := encode[ApiResponse[struct{}]](ApiResponse[struct{}]{
 			Message: "successfully signed up user",
 		}, http.StatusCreated, w); err != nil {
@@ -87,20 +126,93 @@ type SigninResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+var (
+	errInvalidCredentials = errors.New("invalid email or password")
+	errSigninThrottled    = errors.New("too many failed signin attempts")
+)
+
+// signinHandler slows down and then locks out repeated failures per email
+// and per ip. Unknown emails are tracked, delayed and answered exactly like
+// wrong passwords, so responses do not reveal which accounts exist.
 func (s *ApiServer) signinHandler() http.HandlerFunc {
+	policy := NewSigninPolicy(s.Config)
+	dummyUser := newDummyUser()
+
 	return handler(func(w http.ResponseWriter, r *http.Request) error {
 		req, err := decode[SigninRequest](r)
 		if err != nil {
 			return NewErrWithStatus(http.StatusBadRequest, err)
 		}
 
-		user, err := s.store.Users.ByEmail(r.Context(), req.Email)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		// signup stores normalized emails, one that does not normalize has no
+		// account but is still tracked and answered like any unknown email
+		email, normalizeErr := NormalizeEmail(req.Email)
+		if normalizeErr != nil {
+			email = strings.ToLower(strings.TrimSpace(req.Email))
+		}
+
+		logger := logging.FromContext(r.Context(), s.logger)
+		attempt := &store.SigninAttempt{
+			Email: email,
+			Ip:    clientIP(r, s.Config.ApiTrustForwardedFor),
+		}
+
+		// concurrent signins for the email wait here, so each one counts the
+		// failures recorded before it
+		attempts, err := s.store.SigninAttempts.Lock(r.Context(), attempt.Email)
+		if err != nil {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
 		}
+		defer attempts.Rollback()
 
-		if err := user.ComparePassword(req.Password); err != nil {
-			return NewErrWithStatus(http.StatusUnauthorized, err)
+		failures, err := attempts.Failures(r.Context(), attempt.Email, attempt.Ip, s.Config.SigninFailureWindow)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		if wait, locked := policy.Wait(failures, time.Now()); wait > 0 {
+			attempt.Outcome = store.SigninOutcome_Throttled
+			if locked {
+				attempt.Outcome = store.SigninOutcome_Locked
+			}
+			if err := attempts.Record(r.Context(), attempt); err != nil {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+			if err := attempts.Commit(); err != nil {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+			logger.Warn("rejected signin attempt", "outcome", attempt.Outcome, "ip", attempt.Ip, "retry_after", wait)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			return NewErrWithStatus(http.StatusTooManyRequests, errSigninThrottled)
+		}
+
+		var user *store.User
+		if normalizeErr == nil {
+			user, err = s.store.Users.ByEmail(r.Context(), email)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+		}
+
+		passwordUser := dummyUser
+		if user != nil {
+			passwordUser = user
+			attempt.UserId = &user.Id
+		}
+		attempt.Outcome = store.SigninOutcome_Succeeded
+		if err := passwordUser.ComparePassword(req.Password); err != nil || user == nil {
+			attempt.Outcome = store.SigninOutcome_Failed
+		}
+		if err := attempts.Record(r.Context(), attempt); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		if err := attempts.Commit(); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		if attempt.Outcome == store.SigninOutcome_Failed {
+			if failures.Email+1 == policy.LockoutThreshold {
+				logger.Warn("locking signin after repeated failures", "ip", attempt.Ip, "lockout", policy.LockoutDuration)
+			}
+			return NewErrWithStatus(http.StatusUnauthorized, errInvalidCredentials)
 		}
 
 		tokenPair, err := s.JwtManager.GenerateTokenPair(user.Id)
@@ -217,6 +329,9 @@ func (r CreateReportRequest) Validate() error {
 	if r.ReportType == "" {
 		return errors.New("report_type is required")
 	}
+	if !reports.IsReportType(r.ReportType) {
+		return fmt.Errorf("report_type must be one of %s", strings.Join(reports.ReportTypes, ", "))
+	}
 	return nil
 }
 
@@ -234,8 +349,15 @@ type ApiReport struct {
 	Status               string     `json:"status,omitempty"`
 }
 
+// createReportHandler starts the trace of a report, continuing the caller's
+// trace if the request carries one. The worker picks it up from the message.
 func (s *ApiServer) createReportHandler() http.HandlerFunc {
-	return handler(func(w http.ResponseWriter, r *http.Request) error {
+	return handler(func(w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "POST /reports", trace.WithSpanKind(trace.SpanKindServer))
+		defer tracing.EndSpan(span, &err)
+		r = r.WithContext(ctx)
+
 		req, err := decode[CreateReportRequest](r)
 		if err != nil {
 			return NewErrWithStatus(http.StatusBadRequest, err)
@@ -246,35 +368,13 @@ func (s *ApiServer) createReportHandler() http.HandlerFunc {
 			return NewErrWithStatus(http.StatusUnauthorized, errors.New("user not found in context"))
 		}
 
-		report, err := s.store.ReportStore.Create(r.Context(), user.Id, req.ReportType)
-		if err != nil {
-			return NewErrWithStatus(http.StatusInternalServerError, err)
-		}
-
-		sqsMessage := reports.SqsMessage{
-			UserId:   user.Id,
-			ReportId: report.Id,
-		}
-
-		bytes, err := json.Marshal(sqsMessage)
-		if err != nil {
-			return NewErrWithStatus(http.StatusInternalServerError, err)
-		}
-
-		queueUrlOutput, err := s.sqsClient.GetQueueUrl(r.Context(), &sqs.GetQueueUrlInput{
-			QueueName: aws.String(s.Config.SqsQueue),
-		})
-		if err != nil {
-			return NewErrWithStatus(http.StatusInternalServerError, err)
-		}
-
-		_, err = s.sqsClient.SendMessage(r.Context(), &sqs.SendMessageInput{
-			QueueUrl:    queueUrlOutput.QueueUrl,
-			MessageBody: aws.String(string(bytes)),
+		report, err := s.store.ReportStore.CreateWithOutbox(r.Context(), user.Id, req.ReportType, func(report *store.Report) (*store.OutboxMessage, error) {
+			return reports.NewBuildReportMessage(r.Context(), s.Config.SqsQueue, report, report.Id.String())
 		})
 		if err != nil {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
 		}
+		span.SetAttributes(attribute.String("report.id", report.Id.String()))
 
 		if err := encode(ApiResponse[ApiReport]{
 			Data: &ApiReport{
@@ -287,7 +387,7 @@ func (s *ApiServer) createReportHandler() http.HandlerFunc {
 				StartedAt:      report.StartedAt,
 				CompletedAt:    report.CompletedAt,
 				FailedAt:       report.FailedAt,
-				Status:         report.Status(),
+				Status:         string(report.Status),
 			},
 		}, http.StatusCreated, w); err != nil {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
@@ -318,25 +418,29 @@ func (s *ApiServer) getReportHandler() http.HandlerFunc {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
 		}
 
-		if report.CompletedAt != nil && report.ExpiresAt != nil && report.ExpiresAt.Before(time.Now()) {
+		completed := report.Status == store.ReportStatus_Completed
+		if completed && s.encryptor.Mode() == reports.EncryptionClient {
+			// client side encrypted objects are unreadable through a presigned
+			// url, so point at the download proxy which decrypts them
+			downloadUrl := fmt.Sprintf("%s/reports/%s/download", s.Config.PublicUrl(), report.Id)
+			report.DownloadUrl = &downloadUrl
+		} else if completed && report.ExpiresAt != nil && report.ExpiresAt.Before(time.Now()) {
 			// to s3 ppresign client
 			expiresAt := time.Now().Add(time.Second * 10)
-			signedUrl, err := s.presignClient.PresignGetObject(r.Context(), &s3.GetObjectInput{
-				Bucket: aws.String(s.Config.S3Bucket),
-				Key: report.OutputFilePath,
-			}, func(options *s3.PresignOptions) {
-				options.Expires = time.Second * 10
-			})
+			signedUrl, err := s.objectStore.SignedURL(r.Context(), *report.OutputFilePath, time.Second*10)
 			if err != nil {
 				return NewErrWithStatus(http.StatusInternalServerError, err)
 			}
 
-			report.DownloadUrl = &signedUrl.URL
-			report.ExpiresAt = &expiresAt
-			report, err = s.store.ReportStore.Update(r.Context(), report)
+			updated, err := s.store.ReportStore.UpdateDownloadUrl(r.Context(), report.UserID, report.Id, signedUrl, expiresAt)
+			if errors.Is(err, sql.ErrNoRows) {
+				// expired meanwhile, its object is going away
+				updated, err = s.store.ReportStore.ByPrimaryKey(r.Context(), report.UserID, report.Id)
+			}
 			if err != nil {
 				return NewErrWithStatus(http.StatusInternalServerError, err)
 			}
+			report = updated
 		}
 
 		if err := encode(ApiResponse[ApiReport]{
@@ -350,7 +454,7 @@ func (s *ApiServer) getReportHandler() http.HandlerFunc {
 				StartedAt:      report.StartedAt,
 				CompletedAt:    report.CompletedAt,
 				FailedAt:       report.FailedAt,
-				Status:         report.Status(),
+				Status:         string(report.Status),
 			},
 		}, int(http.StatusOK), w); err != nil {
 			return NewErrWithStatus(http.StatusInternalServerError, err)
@@ -359,3 +463,203 @@ func (s *ApiServer) getReportHandler() http.HandlerFunc {
 		return nil
 	})
 }
+
+type ApiReportEvent struct {
+	FromStatus *string           `json:"from_status,omitempty"`
+	ToStatus   string            `json:"to_status"`
+	Actor      string            `json:"actor"`
+	Details    map[string]string `json:"details,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+func (s *ApiServer) reportHistoryHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		reportId, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, errors.New("user not found in context"))
+		}
+
+		if _, err := s.store.ReportStore.ByPrimaryKey(r.Context(), user.Id, reportId); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		events, err := s.store.ReportStore.Events(r.Context(), user.Id, reportId)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		apiEvents := make([]ApiReportEvent, 0, len(events))
+		for _, event := range events {
+			apiEvent := ApiReportEvent{
+				ToStatus:  string(event.ToStatus),
+				Actor:     event.Actor,
+				Details:   event.Details,
+				CreatedAt: event.CreatedAt,
+			}
+			if event.FromStatus != nil {
+				fromStatus := string(*event.FromStatus)
+				apiEvent.FromStatus = &fromStatus
+			}
+			apiEvents = append(apiEvents, apiEvent)
+		}
+
+		if err := encode(ApiResponse[[]ApiReportEvent]{
+			Data: &apiEvents,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// cancelReportHandler cancels a report no worker has started building.
+func (s *ApiServer) cancelReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		reportId, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, errors.New("user not found in context"))
+		}
+
+		if _, err := s.store.ReportStore.ByPrimaryKey(r.Context(), user.Id, reportId); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		report, err := s.store.ReportStore.Cancel(r.Context(), user.Id, reportId)
+		if err != nil {
+			if errors.Is(err, store.ErrReportNotCancellable) {
+				return NewErrWithStatus(http.StatusConflict, ValidationErrors{"status": "can no longer be cancelled"})
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		if err := encode(ApiResponse[ApiReport]{
+			Data: &ApiReport{
+				Id:         report.Id,
+				ReportType: report.ReportType,
+				CreatedAt:  report.CreatedAt,
+				Status:     string(report.Status),
+			},
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) downloadReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		reportId, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, errors.New("user not found in context"))
+		}
+
+		report, err := s.store.ReportStore.ByPrimaryKey(r.Context(), user.Id, reportId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		if report.Status == store.ReportStatus_Expired {
+			return NewErrWithStatus(http.StatusGone, fmt.Errorf("report %s has expired", report.Id))
+		}
+		if report.CompletedAt == nil || report.OutputFilePath == nil {
+			return NewErrWithStatus(http.StatusConflict, fmt.Errorf("report %s is not ready for download", report.Id))
+		}
+
+		object, err := s.objectStore.Get(r.Context(), *report.OutputFilePath)
+		if err != nil {
+			if errors.Is(err, objectstore.ErrNotFound) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		defer object.Body.Close()
+
+		body, err := io.ReadAll(object.Body)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		body, err = s.encryptor.DecryptDownload(user.Id, object.Metadata, body)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		writeObjectHeaders(w, &object.ObjectInfo)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			logging.FromContext(r.Context(), s.logger).Error("failed to write report download", "error", err, "report_id", report.Id)
+		}
+
+		return nil
+	})
+}
+
+// signedObjectHandler serves objects for stores that sign urls themselves.
+// The url signature is the only authorization, like an s3 presigned url.
+func (s *ApiServer) signedObjectHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		verifier, ok := s.objectStore.(objectstore.SignedURLVerifier)
+		if !ok {
+			return NewErrWithStatus(http.StatusNotFound, errors.New("object store does not serve signed urls"))
+		}
+
+		key := r.PathValue("key")
+		if err := verifier.VerifySignedURL(key, r.URL.Query()); err != nil {
+			return NewErrWithStatus(http.StatusForbidden, err)
+		}
+
+		object, err := s.objectStore.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, objectstore.ErrNotFound) {
+				return NewErrWithStatus(http.StatusNotFound, err)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		defer object.Body.Close()
+
+		writeObjectHeaders(w, &object.ObjectInfo)
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, object.Body); err != nil {
+			logging.FromContext(r.Context(), s.logger).Error("failed to write object", "error", err, "key", key)
+		}
+
+		return nil
+	})
+}
+
+func writeObjectHeaders(w http.ResponseWriter, info *objectstore.ObjectInfo) {
+	for header, value := range map[string]string{
+		"Content-Type":        info.ContentType,
+		"Content-Encoding":    info.ContentEncoding,
+		"Content-Disposition": info.ContentDisposition,
+	} {
+		if value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package apiserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-sqs/logging"
 	"log/slog"
 	"net/http"
 )
@@ -23,21 +25,26 @@ func NewErrWithStatus(status int, err error) *ErrWithStatus {
 func handler(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc { // no usages
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
+			logger := logging.FromContext(r.Context(), slog.Default())
 			status := http.StatusInternalServerError
 			msg := http.StatusText(status)
+			var fieldErrs ValidationErrors
 			if e, ok := err.(*ErrWithStatus); ok {
 				status = e.status
 				msg = http.StatusText(e.status)
 				if status == http.StatusBadRequest || status == http.StatusConflict{
 					msg = e.err.Error()
+					errors.As(e.err, &fieldErrs)
 				}
 			}
-			slog.Error("error executing handler", "error", err, "status", status, "msg", msg)
+			logger.Error("error executing handler", "error", err, "status", status, "msg", msg)
 			w.WriteHeader(status)
 			if err := json.NewEncoder(w).Encode(ApiResponse[struct{}]{
-				Message: msg,
+				Message:   msg,
+				Errors:    fieldErrs,
+				RequestId: logging.RequestId(r.Context()),
 			}); err != nil {
-				slog.Error("error encoding response", "error", err)
+				logger.Error("error encoding response", "error", err)
 			}
 		}
 	}
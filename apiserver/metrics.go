@@ -0,0 +1,119 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths do not create a series per path.
+const unmatchedRoute = "unmatched"
+
+// Metrics are the api server metrics of one registry.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+}
+
+// RegisterMetrics creates the api server metrics and registers them with
+// registerer. Each process registers its own, so its metrics endpoint only
+// exports what it records.
+func RegisterMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	metrics := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests handled by the api server.",
+		}, []string{"route", "code"}),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle a request.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of response bodies.",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+		}, []string{"route"}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		metrics.requests,
+		metrics.requestDuration,
+		metrics.responseSize,
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return metrics, nil
+}
+
+// responseRecorder remembers the status and body size written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statusCode is the status sent to the client, which is 200 when the handler
+// never wrote one.
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// routePattern returns the mux pattern r matches, e.g. "GET /reports/{id}".
+// It looks the pattern up itself because the request the mux annotates is a
+// copy once middleware swaps the context.
+func routePattern(mux *http.ServeMux, r *http.Request) string {
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
+}
+
+// NewMetricsMiddleware records requests labelled by the mux pattern they
+// match rather than by path.
+func NewMetricsMiddleware(mux *http.ServeMux, metrics *Metrics) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routePattern(mux, r)
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			metrics.requests.WithLabelValues(route, strconv.Itoa(recorder.statusCode())).Inc()
+			metrics.requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+			metrics.responseSize.WithLabelValues(route).Observe(float64(recorder.size))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package apiserver_test
+
+import (
+	"go-sqs/apiserver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func requestCount(t *testing.T, gatherer prometheus.Gatherer, route string, code string) float64 {
+	families, err := gatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["route"] == route && labels["code"] == code {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := apiserver.RegisterMetrics(registry)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reports/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("report"))
+	})
+	handler := apiserver.NewMetricsMiddleware(mux, metrics)(mux)
+
+	for _, path := range []string{"/reports/1", "/reports/2", "/unknown/path"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// requests are labelled by pattern, not by path
+	require.Equal(t, 2.0, requestCount(t, registry, "GET /reports/{id}", "200"))
+	require.Equal(t, 1.0, requestCount(t, registry, "unmatched", "404"))
+
+	// the worker metrics are not part of the api server registry
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		require.NotContains(t, family.GetName(), "reports_")
+	}
+}
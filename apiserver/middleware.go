@@ -2,6 +2,7 @@ package apiserver
 
 import (
 	"context"
+	"go-sqs/logging"
 	"go-sqs/store"
 	"log/slog"
 	"net/http"
@@ -10,15 +11,59 @@ import (
 	"github.com/google/uuid"
 )
 
-func NewLoggerMiddleware(logger *slog.Logger) func(next http.Handler) http.Handler {
+// Middleware wraps a handler with behaviour shared by many routes.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain applies middlewares to h with the first one outermost, so requests
+// pass through them in the order they are listed.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequestIdHeader carries the id correlating a request with its logs, its
+// response and the messages it publishes.
+const RequestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength bounds ids accepted from callers, longer ones are
+// replaced with a generated id.
+const maxRequestIdLength = 128
+
+// NewRequestIdMiddleware accepts the caller's X-Request-ID or generates one,
+// returns it in the response and stores it in the request context, along
+// with a logger annotated with it.
+func NewRequestIdMiddleware(logger *slog.Logger) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.Info("http request", "method", r.Method, "path", r.URL.Path)
-			next.ServeHTTP(w, r)
+			requestId := r.Header.Get(RequestIdHeader)
+			if !validRequestId(requestId) {
+				requestId = uuid.NewString()
+			}
+			w.Header().Set(RequestIdHeader, requestId)
+
+			ctx := logging.WithRequestId(r.Context(), requestId)
+			ctx = logging.WithLogger(ctx, logger.With(slog.String("request_id", requestId)))
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// validRequestId rejects ids that are empty, too long or contain anything but
+// printable ascii, so they are safe to log and echo back.
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] < '!' || requestId[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 type userCtxKey struct{}
 
 func ContextWithUser(r *http.Request, user *store.User) context.Context {
@@ -33,13 +78,12 @@ func UserFromContext(ctx context.Context) (*store.User, bool) {
 	return user, ok
 }
 
-func NewAuthMiddleware(JwtManager *JwtManager, userStore *store.UserStore) func(next http.Handler) http.Handler {
+// NewAuthMiddleware rejects requests without a valid access token and puts
+// the token's user in the context.
+func NewAuthMiddleware(JwtManager *JwtManager, userStore *store.UserStore) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, "/auth/") {
-				next.ServeHTTP(w, r)
-				return
-			}
+			logger := logging.FromContext(r.Context(), slog.Default())
 
 			authorizationHeader := r.Header.Get("Authorization")
 			var token string
@@ -54,7 +98,7 @@ func NewAuthMiddleware(JwtManager *JwtManager, userStore *store.UserStore) func(
 
 			parsedToken, err := JwtManager.Parse(token)
 			if err != nil {
-				slog.Error("faild to parse token", "error", err)
+				logger.Error("faild to parse token", "error", err)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
@@ -67,26 +111,48 @@ func NewAuthMiddleware(JwtManager *JwtManager, userStore *store.UserStore) func(
 
 			userIdStr, err := parsedToken.Claims.GetSubject()
 			if err != nil {
-				slog.Error("faild to get subject from token", "error", err)
+				logger.Error("faild to get subject from token", "error", err)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
 			userId, err := uuid.Parse(userIdStr)
 			if err != nil {
-				slog.Error("faild to parse user id", "error", err)
+				logger.Error("faild to parse user id", "error", err)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
 			user, err := userStore.ByID(r.Context(), userId)
 			if err != nil {
-				slog.Error("faild to get user by id", "error", err)
+				logger.Error("faild to get user by id", "error", err)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
+			setAccessLogUser(r.Context(), user.Id)
 			next.ServeHTTP(w, r.WithContext(ContextWithUser(r, user)))
 		})
 	}
 }
+
+// NewAdminMiddleware rejects requests from users without the admin flag. It
+// must run after NewAuthMiddleware has put the user in the context.
+func NewAdminMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !user.IsAdmin {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
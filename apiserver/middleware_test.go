@@ -0,0 +1,90 @@
+package apiserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-sqs/apiserver"
+	"go-sqs/logging"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	var seen string
+	handler := apiserver.NewRequestIdMiddleware(slog.Default())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestId(r.Context())
+	}))
+
+	// a caller's id is kept
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(apiserver.RequestIdHeader, "checkout-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, "checkout-42", seen)
+	require.Equal(t, "checkout-42", rec.Header().Get(apiserver.RequestIdHeader))
+
+	// missing or unsafe ids are replaced
+	for _, requestId := range []string{"", "has space", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set(apiserver.RequestIdHeader, requestId)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.NotEmpty(t, seen)
+		require.NotEqual(t, requestId, seen)
+		require.Equal(t, seen, rec.Header().Get(apiserver.RequestIdHeader))
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reports/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("report"))
+	})
+	handler := apiserver.Chain(mux,
+		apiserver.NewRequestIdMiddleware(logger),
+		apiserver.NewAccessLogMiddleware(logger, mux, true),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/reports/1", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	req.Header.Set(apiserver.RequestIdHeader, "request-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.NotContains(t, logs.String(), "secret-token")
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	require.Equal(t, "request-1", line["request_id"])
+	require.Equal(t, "GET /reports/{id}", line["route"])
+	require.Equal(t, float64(http.StatusTeapot), line["status"])
+	require.Equal(t, float64(len("report")), line["bytes"])
+	require.Equal(t, "198.51.100.7", line["client_ip"])
+	require.Equal(t, "[REDACTED]", line["headers"].(map[string]any)["Authorization"])
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) apiserver.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := apiserver.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), record("first"), record("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, []string{"first", "second", "handler"}, order)
+}
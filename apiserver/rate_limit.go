@@ -0,0 +1,67 @@
+package apiserver
+
+import (
+	"go-sqs/config"
+	"go-sqs/logging"
+	"go-sqs/ratelimit"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Route classes share a rate limit bucket per user or client ip.
+const (
+	RateLimitClass_Auth         = "auth"
+	RateLimitClass_CreateReport = "create-report"
+	RateLimitClass_Read         = "read"
+	RateLimitClass_Write        = "write"
+)
+
+// NewRateLimitMiddleware takes a token from the class bucket of the
+// authenticated user, or of the client ip when there is no user, and rejects
+// the request with 429 once the bucket is empty. On authenticated routes it
+// must run after NewAuthMiddleware.
+func NewRateLimitMiddleware(limiter ratelimit.Limiter, class string, limit config.RateLimit, trustForwardedFor bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := class + ":ip:" + clientIP(r, trustForwardedFor)
+			if user, ok := UserFromContext(r.Context()); ok {
+				key = class + ":user:" + user.Id.String()
+			}
+
+			result, err := limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// an unavailable limiter must not take the api down with it
+				logging.FromContext(r.Context(), slog.Default()).Error("failed to apply rate limit", "error", err, "class", class)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				if err := encode(ApiResponse[struct{}]{
+					Message:   http.StatusText(http.StatusTooManyRequests),
+					RequestId: logging.RequestId(r.Context()),
+				}, http.StatusTooManyRequests, w); err != nil {
+					logging.FromContext(r.Context(), slog.Default()).Error("error encoding response", "error", err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, as rate limit headers carry them.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,38 @@
+package apiserver_test
+
+import (
+	"go-sqs/apiserver"
+	"go-sqs/config"
+	"go-sqs/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limit := config.RateLimit{Requests: 1, Per: time.Minute}
+	handler := apiserver.NewRateLimitMiddleware(ratelimit.NewMemoryLimiter(), apiserver.RateLimitClass_Auth, limit, false)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/singin", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/singin", nil))
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "60", rec.Header().Get("Retry-After"))
+	require.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+
+	// requests from another ip have their own bucket
+	req := httptest.NewRequest(http.MethodPost, "/auth/singin", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,66 @@
+package apiserver
+
+import (
+	"go-sqs/config"
+	"net/http"
+	"slices"
+)
+
+// route is a mux pattern with the middleware its handler runs behind. Every
+// route lists its middleware, so a route is only public if it says so.
+type route struct {
+	pattern    string
+	handler    http.Handler
+	middleware []Middleware
+}
+
+func (s *ApiServer) routes() []route {
+	public := []Middleware{}
+	authenticated := []Middleware{NewAuthMiddleware(s.JwtManager, s.store.Users)}
+	adminOnly := slices.Concat(authenticated, []Middleware{NewAdminMiddleware()})
+
+	authLimited := slices.Concat(public, s.rateLimited(RateLimitClass_Auth, s.Config.RateLimitAuth))
+	publicReads := slices.Concat(public, s.rateLimited(RateLimitClass_Read, s.Config.RateLimitRead))
+	reads := slices.Concat(authenticated, s.rateLimited(RateLimitClass_Read, s.Config.RateLimitRead))
+	writes := slices.Concat(authenticated, s.rateLimited(RateLimitClass_Write, s.Config.RateLimitWrite))
+	createReport := slices.Concat(authenticated, s.rateLimited(RateLimitClass_CreateReport, s.Config.RateLimitCreateReport))
+	if s.Config.RequireVerifiedEmail {
+		createReport = slices.Concat(createReport, []Middleware{NewVerifiedEmailMiddleware()})
+	}
+	resendVerification := slices.Concat(authenticated, s.rateLimited(RateLimitClass_Auth, s.Config.RateLimitAuth))
+
+	return []route{
+		{"GET /ping", http.HandlerFunc(s.ping), public},
+		{"POST /auth/singup", s.signupHandler(), authLimited},
+		{"POST /auth/singin", s.signinHandler(), authLimited},
+		{"POST /auth/refresh", s.tokenRefreshHandler(), authLimited},
+		{"POST /auth/verify", s.verifyEmailHandler(), authLimited},
+		{"POST /auth/verify/resend", s.resendVerificationHandler(), resendVerification},
+		// signed object urls carry their own authorization
+		{"GET /objects/{key...}", s.signedObjectHandler(), publicReads},
+
+		{"POST /reports", s.createReportHandler(), createReport},
+		{"GET /reports/{id}", s.getReportHandler(), reads},
+		{"POST /reports/{id}/cancel", s.cancelReportHandler(), writes},
+		{"GET /reports/{id}/download", s.downloadReportHandler(), reads},
+		{"GET /reports/{id}/history", s.reportHistoryHandler(), reads},
+
+		{"GET /admin/dead-letters", s.listDeadLettersHandler(), adminOnly},
+		{"GET /admin/dead-letters/{id}", s.getDeadLetterHandler(), adminOnly},
+		{"POST /admin/dead-letters/{id}/redrive", s.redriveDeadLetterHandler(), adminOnly},
+		{"DELETE /admin/dead-letters/{id}", s.discardDeadLetterHandler(), adminOnly},
+	}
+}
+
+func (s *ApiServer) rateLimited(class string, limit config.RateLimit) []Middleware {
+	return []Middleware{NewRateLimitMiddleware(s.rateLimiter, class, limit, s.Config.ApiTrustForwardedFor)}
+}
+
+// newRouter registers routes on a mux, each wrapped in its own middleware.
+func newRouter(routes []route) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.Handle(route.pattern, Chain(route.handler, route.middleware...))
+	}
+	return mux
+}
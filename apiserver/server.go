@@ -3,15 +3,16 @@ package apiserver
 import (
 	"context"
 	"go-sqs/config"
+	"go-sqs/mailer"
+	"go-sqs/objectstore"
+	"go-sqs/ratelimit"
+	"go-sqs/reports"
 	"go-sqs/store"
 	"log/slog"
 	"net"
 	"net/http"
 	"sync"
 	"time"
-
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
 type ApiServer struct {
@@ -19,18 +20,26 @@ type ApiServer struct {
 	logger *slog.Logger
 	store  *store.Store
 	JwtManager *JwtManager
-	sqsClient *sqs.Client
-	presignClient *s3.PresignClient
+	objectStore objectstore.ObjectStore
+	encryptor *reports.Encryptor
+	rateLimiter ratelimit.Limiter
+	passwordPolicy *PasswordPolicy
+	mailer mailer.Mailer
+	metrics *Metrics
 }
 
-func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager, sqsClient *sqs.Client, presignClient *s3.PresignClient) *ApiServer {
+func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager, objectStore objectstore.ObjectStore, encryptor *reports.Encryptor, rateLimiter ratelimit.Limiter, passwordPolicy *PasswordPolicy, mailer mailer.Mailer, metrics *Metrics) *ApiServer {
 	return &ApiServer{
 		Config: config,
 		logger: logger,
 		store:  store,
 		JwtManager: jwtManager,
-		sqsClient: sqsClient,
-		presignClient: presignClient,
+		objectStore: objectStore,
+		encryptor: encryptor,
+		rateLimiter: rateLimiter,
+		passwordPolicy: passwordPolicy,
+		mailer: mailer,
+		metrics: metrics,
 	}
 }
 
@@ -40,19 +49,18 @@ func (s *ApiServer) ping(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *ApiServer) Start(ctx context.Context) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /ping", s.ping)
-	mux.HandleFunc("POST /auth/singup", s.signupHandler())
-	mux.HandleFunc("POST /auth/singin", s.signinHandler())
-	mux.HandleFunc("POST /auth/refresh", s.tokenRefreshHandler())
-	mux.HandleFunc("POST /reports", s.createReportHandler())
-	mux.HandleFunc("GET /reports/{id}", s.getReportHandler())
+	mux := newRouter(s.routes())
 
-	middleware := NewLoggerMiddleware(s.logger)
-	middleware = NewAuthMiddleware(s.JwtManager, s.store.Users)
+	// middleware shared by every route, route specific middleware is declared
+	// in routes. The request id is set before anything logs.
+	root := Chain(mux,
+		NewMetricsMiddleware(mux, s.metrics),
+		NewRequestIdMiddleware(s.logger),
+		NewAccessLogMiddleware(s.logger, mux, s.Config.ApiTrustForwardedFor),
+	)
 	server := &http.Server{
 		Addr:    net.JoinHostPort(s.Config.ApiServerHost, s.Config.ApiServerPort),
-		Handler: middleware(mux),
+		Handler: root,
 	}
 
 	go func() {
@@ -0,0 +1,96 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/base64"
+	"go-sqs/config"
+	"go-sqs/store"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SigninPolicy decides how long signins for an email, or from an ip, wait
+// after recent failures.
+type SigninPolicy struct {
+	FreeAttempts       int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	LockoutThreshold   int
+	LockoutDuration    time.Duration
+	IpLockoutThreshold int
+}
+
+func NewSigninPolicy(conf *config.Config) SigninPolicy {
+	return SigninPolicy{
+		FreeAttempts:       conf.SigninFreeAttempts,
+		BaseDelay:          conf.SigninBaseDelay,
+		MaxDelay:           conf.SigninMaxDelay,
+		LockoutThreshold:   conf.SigninLockoutThreshold,
+		LockoutDuration:    conf.SigninLockoutDuration,
+		IpLockoutThreshold: conf.SigninIpLockoutThreshold,
+	}
+}
+
+// Retention is how long attempts can still count towards a delay or lockout.
+func (p SigninPolicy) Retention(window time.Duration) time.Duration {
+	return max(window, p.LockoutDuration)
+}
+
+// StartSigninAttemptPruning deletes attempts older than retention every
+// interval until ctx is done.
+func StartSigninAttemptPruning(ctx context.Context, attempts *store.SigninAttemptStore, retention time.Duration, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := attempts.DeleteBefore(ctx, retention); err != nil {
+			logger.Error("failed to delete old signin attempts", "error", err)
+		}
+	}
+}
+
+// Wait returns how long from now the next attempt has to wait, and whether
+// that is a lockout rather than a progressive delay.
+func (p SigninPolicy) Wait(failures *store.SigninFailures, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	locked := false
+
+	if failures.LastIpFailure != nil && failures.Ip >= p.IpLockoutThreshold {
+		wait = failures.LastIpFailure.Add(p.LockoutDuration).Sub(now)
+		locked = wait > 0
+	}
+
+	if failures.LastEmailFailure == nil || failures.Email < p.FreeAttempts {
+		return max(wait, 0), locked
+	}
+	if failures.Email >= p.LockoutThreshold {
+		emailWait := failures.LastEmailFailure.Add(p.LockoutDuration).Sub(now)
+		if emailWait > 0 {
+			return max(wait, emailWait), true
+		}
+		return max(wait, 0), locked
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures.Email && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	return max(wait, failures.LastEmailFailure.Add(delay).Sub(now), 0), locked
+}
+
+// newDummyUser returns a user whose password nobody knows. Signins for
+// unknown emails check the password against it, so they take as long as a
+// wrong password for an existing account.
+func newDummyUser() *store.User {
+	// bcrypt only rejects passwords over 72 bytes, a uuid is 36
+	hash, _ := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), bcrypt.DefaultCost)
+	return &store.User{HashedPasswordBase64: base64.StdEncoding.EncodeToString(hash)}
+}
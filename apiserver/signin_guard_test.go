@@ -0,0 +1,54 @@
+package apiserver_test
+
+import (
+	"go-sqs/apiserver"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigninPolicy(t *testing.T) {
+	policy := apiserver.SigninPolicy{
+		FreeAttempts:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Minute,
+		LockoutThreshold:   10,
+		LockoutDuration:    15 * time.Minute,
+		IpLockoutThreshold: 50,
+	}
+	now := time.Now()
+	lastFailure := now.Add(-time.Second)
+
+	wait, locked := policy.Wait(&store.SigninFailures{Email: 2, LastEmailFailure: &lastFailure}, now)
+	require.Zero(t, wait)
+	require.False(t, locked)
+
+	// delays double with every failure past the free attempts
+	wait, locked = policy.Wait(&store.SigninFailures{Email: 5, LastEmailFailure: &lastFailure}, now)
+	require.Equal(t, 3*time.Second, wait)
+	require.False(t, locked)
+
+	wait, _ = policy.Wait(&store.SigninFailures{Email: 9, LastEmailFailure: &lastFailure}, now)
+	require.Equal(t, 59*time.Second, wait)
+
+	wait, locked = policy.Wait(&store.SigninFailures{Email: 10, LastEmailFailure: &lastFailure}, now)
+	require.Equal(t, 15*time.Minute-time.Second, wait)
+	require.True(t, locked)
+
+	// the lockout ends after its duration
+	longAgo := now.Add(-time.Hour)
+	wait, locked = policy.Wait(&store.SigninFailures{Email: 10, LastEmailFailure: &longAgo}, now)
+	require.Zero(t, wait)
+	require.False(t, locked)
+
+	// an ip guessing across many emails is locked out as a whole
+	wait, locked = policy.Wait(&store.SigninFailures{Ip: 50, LastIpFailure: &lastFailure}, now)
+	require.Equal(t, 15*time.Minute-time.Second, wait)
+	require.True(t, locked)
+
+	// attempts are kept for as long as they can delay or lock a signin
+	require.Equal(t, time.Hour, policy.Retention(time.Hour))
+	require.Equal(t, 15*time.Minute, policy.Retention(time.Minute))
+}
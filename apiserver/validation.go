@@ -0,0 +1,121 @@
+package apiserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-sqs/config"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxEmailLength and maxEmailLocalLength are the limits of RFC 5321.
+	maxEmailLength      = 254
+	maxEmailLocalLength = 64
+	// maxPasswordBytes is the most bcrypt hashes, it ignores anything longer.
+	maxPasswordBytes = 72
+)
+
+// ValidationErrors maps request fields to what is wrong with them. Handlers
+// return it as the error of a bad request and clients get it per field.
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+" "+e[field])
+	}
+	return strings.Join(messages, ", ")
+}
+
+// add records message for field unless the field already has an error, and
+// returns the possibly allocated map.
+func (e ValidationErrors) add(field string, message string) ValidationErrors {
+	if e == nil {
+		e = ValidationErrors{}
+	}
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+	return e
+}
+
+// NormalizeEmail checks that email is a bare RFC 5322 address within the
+// length limits of RFC 5321 and returns it case folded, which is how
+// addresses are compared and stored.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", errors.New("is not a valid email address")
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	if len(email) > maxEmailLength || len(local) > maxEmailLocalLength {
+		return "", errors.New("is too long")
+	}
+	if strings.HasPrefix(domain, "[") || !strings.Contains(domain, ".") {
+		return "", errors.New("must have a domain name")
+	}
+	return strings.ToLower(email), nil
+}
+
+// PasswordPolicy rejects passwords that are short, longer than bcrypt
+// hashes or on a list of breached passwords.
+type PasswordPolicy struct {
+	minLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy loads the breached password list, one password per line,
+// from PASSWORD_BREACHED_LIST when it is set.
+func NewPasswordPolicy(conf *config.Config) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		minLength: conf.PasswordMinLength,
+		breached:  map[string]struct{}{},
+	}
+	if conf.PasswordBreachedList == "" {
+		return policy, nil
+	}
+
+	f, err := os.Open(conf.PasswordBreachedList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimRight(scanner.Text(), "\r"); password != "" {
+			policy.breached[password] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return policy, nil
+}
+
+func (p *PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Errorf("must be at least %d characters", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("must be at most %d bytes", maxPasswordBytes)
+	}
+	if _, ok := p.breached[password]; ok {
+		return errors.New("appears in a list of breached passwords")
+	}
+	return nil
+}
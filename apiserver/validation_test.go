@@ -0,0 +1,65 @@
+package apiserver_test
+
+import (
+	"go-sqs/apiserver"
+	"go-sqs/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := apiserver.NormalizeEmail("  Jane.Doe+Reports@Example.COM ")
+	require.NoError(t, err)
+	require.Equal(t, "jane.doe+reports@example.com", email)
+
+	for _, invalid := range []string{
+		"jane",
+		"jane@",
+		"Jane <jane@example.com>",
+		"<jane@example.com>",
+		"jane@localhost",
+		"jane@[192.0.2.1]",
+		strings.Repeat("a", 65) + "@example.com",
+		"jane@" + strings.Repeat("a", 250) + ".com",
+	} {
+		_, err := apiserver.NormalizeEmail(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(breached, []byte("password123\r\nqwertyuiop\n"), 0o644))
+
+	policy, err := apiserver.NewPasswordPolicy(&config.Config{PasswordMinLength: 8, PasswordBreachedList: breached})
+	require.NoError(t, err)
+
+	require.NoError(t, policy.Check("correct horse battery"))
+	require.ErrorContains(t, policy.Check("short"), "at least 8")
+	require.ErrorContains(t, policy.Check(strings.Repeat("a", 73)), "at most 72 bytes")
+	require.ErrorContains(t, policy.Check("password123"), "breached")
+	require.ErrorContains(t, policy.Check("qwertyuiop"), "breached")
+
+	_, err = apiserver.NewPasswordPolicy(&config.Config{PasswordBreachedList: filepath.Join(t.TempDir(), "missing.txt")})
+	require.Error(t, err)
+}
+
+func TestSignupRequestValidate(t *testing.T) {
+	err := apiserver.SignupRequest{Email: "not-an-email"}.Validate()
+	var fieldErrs apiserver.ValidationErrors
+	require.ErrorAs(t, err, &fieldErrs)
+	require.Equal(t, "is not a valid email address", fieldErrs["email"])
+	require.Equal(t, "is required", fieldErrs["password"])
+
+	require.NoError(t, apiserver.SignupRequest{Email: "jane@example.com", Password: "secret"}.Validate())
+}
+
+func TestCreateReportRequestValidate(t *testing.T) {
+	require.NoError(t, apiserver.CreateReportRequest{ReportType: "monsters"}.Validate())
+	require.ErrorContains(t, apiserver.CreateReportRequest{}.Validate(), "report_type is required")
+	require.ErrorContains(t, apiserver.CreateReportRequest{ReportType: "anything"}.Validate(), "report_type must be one of monsters")
+}
@@ -5,12 +5,22 @@ import (
 	"fmt"
 	"go-sqs/apiserver"
 	"go-sqs/config"
+	"go-sqs/health"
+	"go-sqs/mailer"
+	"go-sqs/objectstore"
+	"go-sqs/queue"
+	"go-sqs/ratelimit"
+	"go-sqs/reports"
 	"go-sqs/store"
+	"go-sqs/tracing"
 	"log"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -18,6 +28,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 func main() {
@@ -41,6 +53,20 @@ func run() error {
 
 	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
 	logger := slog.New(jsonHandler)
+
+	shutdownTracing, err := tracing.Setup(ctx, conf, "apiserver")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// ctx is cancelled by now, flushing spans gets its own deadline
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
 	db, err := store.NewPostgresDB(conf)
 	if err != nil {
 		return err
@@ -73,7 +99,73 @@ func run() error {
 
 	presignClient := s3.NewPresignClient(s3Client)
 
-	server := apiserver.New(conf, logger, dataStore, jwtManager, sqsClient, presignClient)
+	objectStore, err := objectstore.New(conf, s3Client, presignClient)
+	if err != nil {
+		return err
+	}
+
+	encryptor, err := reports.NewEncryptor(conf)
+	if err != nil {
+		return err
+	}
+
+	// the relay also publishes to the worker queues so redriven dead letters
+	// go back to the queue they came from
+	queues := map[string]queue.JobQueue{}
+	for _, name := range append([]string{conf.SqsQueue}, slices.Collect(maps.Keys(conf.WorkerQueues))...) {
+		jobQueue, err := queue.New(conf, name, sqsClient, dataStore.Jobs)
+		if err != nil {
+			return err
+		}
+		queues[name] = jobQueue
+	}
+
+	relay := reports.NewOutboxRelay(dataStore.Outbox, queues, logger, conf.OutboxPollInterval, conf.OutboxRetention)
+	go func() {
+		if err := relay.Start(ctx); err != nil {
+			logger.Error("outbox relay stopped", "error", err)
+		}
+	}()
+
+	// only the api server metrics, the worker exports its own
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics, err := apiserver.RegisterMetrics(registry)
+	if err != nil {
+		return err
+	}
+
+	// metrics and health checks live on their own listener so they stay
+	// outside auth and off the public port
+	if conf.ApiAdminAddr != "" {
+		checks := map[string]health.Check{"db": db.PingContext}
+		go func() {
+			if err := health.Serve(ctx, conf.ApiAdminAddr, health.NewHandler(registry, checks), logger); err != nil {
+				logger.Error("admin server stopped", "error", err)
+			}
+		}()
+	}
+
+	rateLimiter, err := ratelimit.New(conf, dataStore.RateLimits)
+	if err != nil {
+		return err
+	}
+	go ratelimit.StartPruning(ctx, rateLimiter, time.Minute, logger)
+	go apiserver.StartVerificationPruning(ctx, dataStore.EmailVerifications, time.Hour, logger)
+	signinRetention := apiserver.NewSigninPolicy(conf).Retention(conf.SigninFailureWindow)
+	go apiserver.StartSigninAttemptPruning(ctx, dataStore.SigninAttempts, signinRetention, time.Hour, logger)
+
+	passwordPolicy, err := apiserver.NewPasswordPolicy(conf)
+	if err != nil {
+		return err
+	}
+
+	mail, err := mailer.New(conf, logger)
+	if err != nil {
+		return err
+	}
+
+	server := apiserver.New(conf, logger, dataStore, jwtManager, objectStore, encryptor, rateLimiter, passwordPolicy, mail, metrics)
 	if err = server.Start(ctx); err != nil {
 		return err
 	}
@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"go-sqs/config"
+	"go-sqs/health"
+	"go-sqs/objectstore"
+	"go-sqs/queue"
 	"go-sqs/reports"
 	"go-sqs/store"
+	"go-sqs/tracing"
 	"log"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -18,6 +25,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 func main() {
@@ -32,7 +41,7 @@ func run() error {
 		return err
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	conf, err := config.New()
@@ -71,12 +80,84 @@ func run() error {
 
 	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
 	logger := slog.New(jsonHandler)
+
+	shutdownTracing, err := tracing.Setup(ctx, conf, "worker")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// ctx is cancelled by now, flushing spans gets its own deadline
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
 	lozClient := reports.NewLozClient(&http.Client{Timeout: time.Second * 10})
-	builder := reports.NewReportBuilder(dataStore.ReportStore, lozClient, s3Client, conf, logger)
+	objectStore, err := objectstore.New(conf, s3Client, s3.NewPresignClient(s3Client))
+	if err != nil {
+		return err
+	}
 
+	encryptor, err := reports.NewEncryptor(conf)
+	if err != nil {
+		return err
+	}
+	builder := reports.NewReportBuilder(dataStore.ReportStore, lozClient, objectStore, encryptor, conf, logger)
+
+	weights := conf.QueueWeights()
+	names := slices.Sorted(maps.Keys(weights))
+	queues := make([]reports.WeightedQueue, 0, len(names))
+	for _, name := range names {
+		jobQueue, err := queue.New(conf, name, sqsClient, dataStore.Jobs)
+		if err != nil {
+			return err
+		}
+		queues = append(queues, reports.WeightedQueue{Name: name, Queue: jobQueue, Weight: weights[name]})
+	}
+
+	reaper := reports.NewLeaseReaper(dataStore.ReportStore, conf.SqsQueue, logger, conf.ReportReapInterval)
+	go func() {
+		if err := reaper.Start(ctx); err != nil {
+			logger.Error("lease reaper stopped", "error", err)
+		}
+	}()
+
+	if conf.ReportRetention > 0 {
+		expirer := reports.NewReportExpirer(dataStore.ReportStore, objectStore, logger, conf.ReportRetention, conf.ReportExpiryInterval)
+		go func() {
+			if err := expirer.Start(ctx); err != nil {
+				logger.Error("report expirer stopped", "error", err)
+			}
+		}()
+	}
+
+	worker := reports.NewWorker(conf, builder, logger, queues, dataStore.DeadLetters)
 
-	maxConcurrency := 2
-	worker := reports.NewWorker(conf, builder, logger, sqsClient, maxConcurrency)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if err := reports.RegisterMetrics(registry); err != nil {
+		return err
+	}
+
+	if conf.WorkerMetricsAddr != "" {
+		checks := map[string]health.Check{"db": db.PingContext}
+		for _, source := range queues {
+			if depth, ok := source.Queue.(queue.DepthReporter); ok {
+				checks["queue:"+source.Name] = func(ctx context.Context) error {
+					_, err := depth.Depth(ctx)
+					return err
+				}
+			}
+		}
+
+		go func() {
+			if err := health.Serve(ctx, conf.WorkerMetricsAddr, health.NewHandler(registry, checks), logger); err != nil {
+				logger.Error("metrics server stopped", "error", err)
+			}
+		}()
+	}
 
 	if err := worker.Start(ctx); err != nil {
 		return err
@@ -2,6 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 )
@@ -16,6 +21,52 @@ const (
 type Config struct {
 	ApiServerPort        string `env:"APISERVER_PORT"`
 	ApiServerHost        string `env:"APISERVER_HOST"`
+	// ApiTrustForwardedFor takes the client ip from the last X-Forwarded-For
+	// entry, only enable it behind a proxy that sets the header
+	ApiTrustForwardedFor bool `env:"APISERVER_TRUST_FORWARDED_FOR" envDefault:"false"`
+	// ApiPublicUrl is the address clients reach the api server at, e.g.
+	// https://api.example.com behind a tls terminating proxy. Urls handed to
+	// clients are built from it. It defaults to http on the bind address,
+	// which only works for local development.
+	ApiPublicUrl string `env:"API_PUBLIC_URL"`
+	// ApiAdminAddr serves health checks and metrics, disabled when empty
+	ApiAdminAddr string `env:"APISERVER_ADMIN_ADDR"`
+	// RateLimitBackend is memory or postgres. Instances only share limits
+	// through postgres.
+	RateLimitBackend      string    `env:"RATE_LIMIT_BACKEND" envDefault:"memory"`
+	RateLimitAuth         RateLimit `env:"RATE_LIMIT_AUTH" envDefault:"10/1m"`
+	RateLimitCreateReport RateLimit `env:"RATE_LIMIT_CREATE_REPORT" envDefault:"5/1m"`
+	RateLimitRead         RateLimit `env:"RATE_LIMIT_READ" envDefault:"120/1m"`
+	// RateLimitWrite covers changes to existing reports, like cancelling them
+	RateLimitWrite RateLimit `env:"RATE_LIMIT_WRITE" envDefault:"30/1m"`
+	PasswordMinLength int `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	// PasswordBreachedList is a file of rejected passwords, one per line
+	PasswordBreachedList string `env:"PASSWORD_BREACHED_LIST"`
+	// after SigninFreeAttempts failures within SigninFailureWindow each
+	// further attempt on the email waits twice as long as the last, and
+	// SigninLockoutThreshold failures lock it for SigninLockoutDuration
+	SigninFailureWindow      time.Duration `env:"SIGNIN_FAILURE_WINDOW" envDefault:"1h"`
+	SigninFreeAttempts       int           `env:"SIGNIN_FREE_ATTEMPTS" envDefault:"3"`
+	SigninBaseDelay          time.Duration `env:"SIGNIN_BASE_DELAY" envDefault:"1s"`
+	SigninMaxDelay           time.Duration `env:"SIGNIN_MAX_DELAY" envDefault:"1m"`
+	SigninLockoutThreshold   int           `env:"SIGNIN_LOCKOUT_THRESHOLD" envDefault:"10"`
+	SigninLockoutDuration    time.Duration `env:"SIGNIN_LOCKOUT_DURATION" envDefault:"15m"`
+	SigninIpLockoutThreshold int           `env:"SIGNIN_IP_LOCKOUT_THRESHOLD" envDefault:"50"`
+	// Mailer is log or smtp, the log mailer only writes messages to the log
+	// and is refused unless Env is dev or test
+	Mailer       string `env:"MAILER" envDefault:"log"`
+	MailFrom     string `env:"MAIL_FROM"`
+	SmtpAddr     string `env:"SMTP_ADDR"`
+	SmtpUsername string `env:"SMTP_USERNAME"`
+	SmtpPassword string `env:"SMTP_PASSWORD"`
+	// EmailVerificationUrl is the page verification emails link to, with the
+	// token appended as the token query parameter. Without it emails only
+	// contain the token to post to /auth/verify.
+	EmailVerificationUrl string        `env:"EMAIL_VERIFICATION_URL"`
+	EmailVerificationTtl time.Duration `env:"EMAIL_VERIFICATION_TTL" envDefault:"24h"`
+	// RequireVerifiedEmail rejects report creation until the user's email is
+	// verified. Users created before verification existed count as verified.
+	RequireVerifiedEmail bool `env:"REQUIRE_VERIFIED_EMAIL" envDefault:"false"`
 	DatabaseName         string `env:"DB_NAME"`
 	DatabaseHost         string `env:"DB_HOST"`
 	DatabasePort         string `env:"DB_PORT"`
@@ -31,6 +82,108 @@ type Config struct {
 	LocalstackEndpoint   string `env:"LOCALSTACK_ENDPOINT"`
 	S3Bucket             string `env:"S3_BUCKET"`
 	SqsQueue             string `env:"SQS_QUEUE"`
+	ReportEncryption     string `env:"REPORT_ENCRYPTION" envDefault:"none"`
+	ReportKmsKeyId       string `env:"REPORT_KMS_KEY_ID"`
+	ReportEncryptionKey  string `env:"REPORT_ENCRYPTION_KEY"`
+	ObjectStore          string `env:"OBJECT_STORE" envDefault:"s3"`
+	ObjectStoreDir       string `env:"OBJECT_STORE_DIR" envDefault:"tmp/objects"`
+	ObjectStoreSigningKey string `env:"OBJECT_STORE_SIGNING_KEY"`
+	// JobQueue is sqs or postgres, the queue the api server and worker share
+	JobQueue             string        `env:"JOB_QUEUE" envDefault:"sqs"`
+	JobVisibilityTimeout time.Duration `env:"JOB_VISIBILITY_TIMEOUT" envDefault:"30s"`
+	OutboxPollInterval   time.Duration `env:"OUTBOX_POLL_INTERVAL" envDefault:"1s"`
+	// OutboxRetention is how long published outbox messages are kept
+	OutboxRetention time.Duration `env:"OUTBOX_RETENTION" envDefault:"24h"`
+	WorkerMaxReceives    int           `env:"WORKER_MAX_RECEIVES" envDefault:"5"`
+	WorkerBuildTimeout   time.Duration `env:"WORKER_BUILD_TIMEOUT" envDefault:"10s"`
+	// WorkerHeartbeatInterval must be well below JobVisibilityTimeout so a
+	// running build is extended before its message becomes visible again
+	WorkerHeartbeatInterval time.Duration `env:"WORKER_HEARTBEAT_INTERVAL" envDefault:"10s"`
+	WorkerDrainTimeout      time.Duration `env:"WORKER_DRAIN_TIMEOUT" envDefault:"30s"`
+	// WorkerAckBatchSize is at most MaxAckBatchSize, the entries SQS accepts
+	// in one DeleteMessageBatch
+	WorkerAckBatchSize      int           `env:"WORKER_ACK_BATCH_SIZE" envDefault:"10"`
+	WorkerAckFlushInterval  time.Duration `env:"WORKER_ACK_FLUSH_INTERVAL" envDefault:"1s"`
+	WorkerMinConcurrency    int           `env:"WORKER_MIN_CONCURRENCY" envDefault:"1"`
+	WorkerMaxConcurrency    int           `env:"WORKER_MAX_CONCURRENCY" envDefault:"2"`
+	WorkerScaleInterval     time.Duration `env:"WORKER_SCALE_INTERVAL" envDefault:"15s"`
+	// WorkerQueues maps queue names to weights, e.g. "interactive:3,scheduled:1".
+	// It must include SqsQueue, where the api server publishes reports.
+	WorkerQueues           map[string]int `env:"WORKER_QUEUES"`
+	WorkerMaxBuildsPerUser int            `env:"WORKER_MAX_BUILDS_PER_USER" envDefault:"1"`
+	// WorkerFairnessDelay is how long a message waits before it is tried again
+	// when its user is at WorkerMaxBuildsPerUser. Deferred messages are
+	// requeued as new messages, so waiting does not count as a receive. FIFO
+	// queues are not deferred, their groups already run one build per user.
+	WorkerFairnessDelay time.Duration `env:"WORKER_FAIRNESS_DELAY" envDefault:"5s"`
+	WorkerUnsupportedVersionDelay time.Duration `env:"WORKER_UNSUPPORTED_VERSION_DELAY" envDefault:"30s"`
+	// WorkerId names the process in report leases, defaults to host and pid
+	WorkerId                 string        `env:"WORKER_ID"`
+	ReportLeaseDuration      time.Duration `env:"REPORT_LEASE_DURATION" envDefault:"1m"`
+	ReportLeaseRenewInterval time.Duration `env:"REPORT_LEASE_RENEW_INTERVAL" envDefault:"20s"`
+	ReportReapInterval       time.Duration `env:"REPORT_REAP_INTERVAL" envDefault:"30s"`
+	// ReportRetention is how long completed reports stay downloadable before
+	// they expire and their objects are deleted, 0 keeps them forever
+	ReportRetention      time.Duration `env:"REPORT_RETENTION" envDefault:"0"`
+	ReportExpiryInterval time.Duration `env:"REPORT_EXPIRY_INTERVAL" envDefault:"1m"`
+	// TracesExporter is one of none, stdout, file or otlp. The otlp exporter
+	// reads the standard OTEL_EXPORTER_OTLP_* variables.
+	TracesExporter string `env:"OTEL_TRACES_EXPORTER" envDefault:"none"`
+	TracesFile     string `env:"OTEL_TRACES_FILE" envDefault:"traces.jsonl"`
+	// WorkerMetricsAddr serves health checks and metrics, disabled when empty
+	WorkerMetricsAddr string `env:"WORKER_METRICS_ADDR"`
+}
+
+// QueueWeights returns the queues the worker consumes and their weights. It
+// falls back to SqsQueue alone when WorkerQueues is not set.
+func (c *Config) QueueWeights() map[string]int {
+	if len(c.WorkerQueues) == 0 {
+		return map[string]int{c.SqsQueue: 1}
+	}
+	return c.WorkerQueues
+}
+
+// RateLimit is a token bucket holding Requests tokens that refills completely
+// every Per, written as "10/1m". An empty value or 0 requests disables it.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l *RateLimit) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" || value == "0" {
+		*l = RateLimit{}
+		return nil
+	}
+
+	requests, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("invalid rate limit %q, want requests/duration", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid duration in rate limit %q", value)
+	}
+	*l = RateLimit{Requests: n, Per: d}
+	return nil
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// PublicUrl returns ApiPublicUrl without a trailing slash, falling back to
+// the bind address.
+func (c *Config) PublicUrl() string {
+	if c.ApiPublicUrl != "" {
+		return strings.TrimSuffix(c.ApiPublicUrl, "/")
+	}
+	return "http://" + net.JoinHostPort(c.ApiServerHost, c.ApiServerPort)
 }
 
 func (c *Config) DatabaseUrl() string {
@@ -47,6 +200,9 @@ func (c *Config) DatabaseUrl() string {
 	)
 }
 
+// MaxAckBatchSize is the most messages SQS deletes in one batch request.
+const MaxAckBatchSize = 10
+
 func New() (*Config, error) {
 	cfg, err := env.ParseAs[Config]()
 
@@ -54,5 +210,31 @@ func New() (*Config, error) {
 		return &cfg, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if cfg.WorkerMinConcurrency < 1 || cfg.WorkerMaxConcurrency < cfg.WorkerMinConcurrency {
+		return &cfg, fmt.Errorf("invalid worker concurrency: min %d, max %d", cfg.WorkerMinConcurrency, cfg.WorkerMaxConcurrency)
+	}
+
+	if cfg.WorkerAckBatchSize < 1 || cfg.WorkerAckBatchSize > MaxAckBatchSize {
+		return &cfg, fmt.Errorf("invalid worker ack batch size %d, want 1 to %d", cfg.WorkerAckBatchSize, MaxAckBatchSize)
+	}
+
+	if cfg.ApiPublicUrl != "" {
+		u, err := url.Parse(cfg.ApiPublicUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &cfg, fmt.Errorf("invalid API_PUBLIC_URL %q, want an absolute http or https url", cfg.ApiPublicUrl)
+		}
+	}
+
+	for name, weight := range cfg.WorkerQueues {
+		if weight < 1 {
+			return &cfg, fmt.Errorf("invalid weight %d for worker queue %s", weight, name)
+		}
+	}
+	// the api server and the lease reaper publish to SqsQueue, a worker not
+	// consuming it would leave new reports requested forever
+	if _, ok := cfg.QueueWeights()[cfg.SqsQueue]; !ok {
+		return &cfg, fmt.Errorf("WORKER_QUEUES must include SQS_QUEUE %q, which reports are published to", cfg.SqsQueue)
+	}
+
 	return &cfg, nil
 }
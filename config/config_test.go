@@ -0,0 +1,45 @@
+package config_test
+
+import (
+	"go-sqs/config"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueWeights(t *testing.T) {
+	t.Setenv("SQS_QUEUE", "interactive")
+
+	conf, err := config.New()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"interactive": 1}, conf.QueueWeights())
+
+	t.Setenv("WORKER_QUEUES", "interactive:3,scheduled:1")
+	conf, err = config.New()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"interactive": 3, "scheduled": 1}, conf.QueueWeights())
+
+	// nothing would consume the reports the api server publishes
+	t.Setenv("WORKER_QUEUES", "scheduled:1")
+	_, err = config.New()
+	require.ErrorContains(t, err, "must include SQS_QUEUE")
+
+	t.Setenv("WORKER_QUEUES", "interactive:0")
+	_, err = config.New()
+	require.ErrorContains(t, err, "invalid weight")
+}
+
+func TestAckBatchSize(t *testing.T) {
+	t.Setenv("WORKER_ACK_BATCH_SIZE", "10")
+	_, err := config.New()
+	require.NoError(t, err)
+
+	// SQS rejects larger batches, so every ack would fail
+	t.Setenv("WORKER_ACK_BATCH_SIZE", "11")
+	_, err = config.New()
+	require.ErrorContains(t, err, "invalid worker ack batch size")
+
+	t.Setenv("WORKER_ACK_BATCH_SIZE", "0")
+	_, err = config.New()
+	require.ErrorContains(t, err, "invalid worker ack batch size")
+}
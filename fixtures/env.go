@@ -53,6 +53,6 @@ func (te *TestEnv) SetupDb(t *testing.T) func(t *testing.T) {
 }
 
 func (te *TestEnv) TeardownDb(t *testing.T) {
-	_, err := te.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s", strings.Join([]string{"users", "refresh_tokens", "reports"}, ", ")))
+	_, err := te.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s", strings.Join([]string{"users", "refresh_tokens", "reports", "jobs", "outbox", "dead_letters", "report_events", "rate_limits", "signin_attempts", "email_verification_tokens"}, ", ")))
 	require.NoError(t, err)
 }
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// checkTimeout bounds how long /readyz waits for a single dependency.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency the process needs is reachable.
+type Check func(ctx context.Context) error
+
+type readyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewHandler serves /healthz, which only shows that the process is up,
+// /readyz, which runs every check, and /metrics from gatherer, the registry
+// of the process being served.
+func NewHandler(gatherer prometheus.Gatherer, checks map[string]Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		response := readyResponse{Status: "ok", Checks: map[string]string{}}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+				defer cancel()
+
+				result := "ok"
+				if err := check(ctx); err != nil {
+					result = err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				response.Checks[name] = result
+				if result != "ok" {
+					response.Status = "unavailable"
+				}
+			}()
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		if response.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.Handle("GET /metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Serve runs handler on addr until ctx is cancelled.
+func Serve(ctx context.Context, addr string, handler http.Handler, logger *slog.Logger) error {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shut down health server", "error", err)
+		}
+	}()
+
+	logger.Info("serving health and metrics", slog.String("addr", addr))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-sqs/health"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	checks := map[string]health.Check{
+		"db":    func(ctx context.Context) error { return nil },
+		"queue": func(ctx context.Context) error { return nil },
+	}
+
+	registry := prometheus.NewRegistry()
+	served := prometheus.NewCounter(prometheus.CounterOpts{Name: "served_total", Help: "Served."})
+	registry.MustRegister(served)
+	served.Inc()
+
+	server := httptest.NewServer(health.NewHandler(registry, checks))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/readyz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// /metrics serves the registry it is given, not the default one
+	res, err = http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	metrics, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(metrics), "served_total 1")
+	require.NotContains(t, string(metrics), "go_goroutines")
+
+	checks["db"] = func(ctx context.Context) error { return dbErr }
+	failing := httptest.NewServer(health.NewHandler(registry, checks))
+	defer failing.Close()
+
+	res, err = http.Get(failing.URL + "/readyz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.Equal(t, "unavailable", body.Status)
+	require.Equal(t, dbErr.Error(), body.Checks["db"])
+	require.Equal(t, "ok", body.Checks["queue"])
+}
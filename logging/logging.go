@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerCtxKey struct{}
+
+type requestIdCtxKey struct{}
+
+// WithLogger returns ctx carrying logger, usually one already annotated with
+// the request or message being handled.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or fallback if there is none.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(loggerCtxKey{}).(*slog.Logger); ok && logger != nil {
+		return logger
+	}
+	return fallback
+}
+
+// WithRequestId returns ctx carrying the id of the api request it serves.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey{}, requestId)
+}
+
+// RequestId returns the id of the api request ctx serves, or "" if it serves
+// none.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdCtxKey{}).(string)
+	return requestId
+}
@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+	"go-sqs/logging"
+	"log/slog"
+)
+
+// LogMailer writes messages to the log instead of sending them. It is meant
+// for development, where following a verification link from the log is
+// easier than running a mail server.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	logging.FromContext(ctx, m.logger).Info("email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-sqs/config"
+	"log/slog"
+	"strings"
+)
+
+type Backend string
+
+const (
+	Backend_Log  Backend = "log"
+	Backend_Smtp Backend = "smtp"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// validate rejects line breaks in header values, which would let a caller
+// inject headers or recipients.
+func (m Message) validate() error {
+	if m.To == "" {
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("message headers must not contain line breaks")
+	}
+	return nil
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New builds the mailer selected by MAILER. The log mailer writes live
+// verification tokens to the log, so it is refused outside dev and test.
+func New(conf *config.Config, logger *slog.Logger) (Mailer, error) {
+	switch Backend(conf.Mailer) {
+	case Backend_Log, "":
+		if conf.Env != config.Env_Dev && conf.Env != config.Env_Test {
+			return nil, fmt.Errorf("mailer %q logs verification tokens and is only allowed in dev and test, use smtp in %q", Backend_Log, conf.Env)
+		}
+		return NewLogMailer(logger), nil
+	case Backend_Smtp:
+		return NewSmtpMailer(conf.SmtpAddr, conf.SmtpUsername, conf.SmtpPassword, conf.MailFrom)
+	}
+	return nil, fmt.Errorf("unknown mailer %q", conf.Mailer)
+}
@@ -0,0 +1,56 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"go-sqs/config"
+	"go-sqs/mailer"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	m, err := mailer.New(&config.Config{Env: config.Env_Dev, Mailer: "log"}, slog.Default())
+	require.NoError(t, err)
+	require.IsType(t, &mailer.LogMailer{}, m)
+
+	// the log mailer would put live verification tokens in production logs
+	_, err = mailer.New(&config.Config{Env: "production", Mailer: "log"}, slog.Default())
+	require.ErrorContains(t, err, "only allowed in dev and test")
+
+	m, err = mailer.New(&config.Config{Mailer: "smtp", SmtpAddr: "localhost:1025", MailFrom: "reports@localhost"}, slog.Default())
+	require.NoError(t, err)
+	require.IsType(t, &mailer.SmtpMailer{}, m)
+
+	_, err = mailer.New(&config.Config{Mailer: "smtp"}, slog.Default())
+	require.Error(t, err)
+
+	_, err = mailer.New(&config.Config{Mailer: "pigeon"}, slog.Default())
+	require.Error(t, err)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	ctx := context.Background()
+	m := mailer.NewMemoryMailer()
+
+	msg := mailer.Message{To: "jane@example.com", Subject: "Hello", Body: "body"}
+	require.NoError(t, m.Send(ctx, msg))
+	require.Equal(t, []mailer.Message{msg}, m.Sent())
+
+	// header injection is rejected by every mailer
+	require.Error(t, m.Send(ctx, mailer.Message{To: "jane@example.com\r\nBcc: eve@example.com", Subject: "Hello"}))
+	require.Error(t, m.Send(ctx, mailer.Message{To: "jane@example.com", Subject: "Hello\nBcc: eve@example.com"}))
+	require.Error(t, m.Send(ctx, mailer.Message{Subject: "Hello"}))
+	require.Len(t, m.Sent(), 1)
+}
+
+func TestLogMailer(t *testing.T) {
+	var logs bytes.Buffer
+	m := mailer.NewLogMailer(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	require.NoError(t, m.Send(context.Background(), mailer.Message{To: "jane@example.com", Subject: "Hello", Body: "token abc"}))
+	require.Contains(t, logs.String(), `"to":"jane@example.com"`)
+	require.Contains(t, logs.String(), "token abc")
+}
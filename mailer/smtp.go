@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SmtpMailer sends messages through an smtp relay, authenticating with PLAIN
+// auth when a username is set. net/smtp only allows that over tls or to
+// localhost.
+type SmtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSmtpMailer(addr, username, password, from string) (*SmtpMailer, error) {
+	if addr == "" {
+		return nil, errors.New("smtp address is required")
+	}
+	if from == "" {
+		return nil, errors.New("mail from address is required")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address %s: %w", addr, err)
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SmtpMailer{addr: addr, auth: auth, from: from}, nil
+}
+
+// Send delivers msg. net/smtp takes no context, so a cancelled ctx only
+// stops messages that have not started sending.
+func (m *SmtpMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, m.build(msg)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+func (m *SmtpMailer) build(msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return b.Bytes()
+}
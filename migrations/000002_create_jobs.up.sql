@@ -0,0 +1,12 @@
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR NOT NULL,
+    body BYTEA NOT NULL,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    receipt UUID,
+    receive_count INTEGER NOT NULL DEFAULT 0,
+    visible_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX jobs_queue_visible_at_idx ON jobs (queue, visible_at);
@@ -0,0 +1,16 @@
+CREATE TABLE dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    queue VARCHAR NOT NULL,
+    message_id VARCHAR NOT NULL,
+    body BYTEA NOT NULL,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    receive_count INTEGER NOT NULL,
+    reason VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    redriven_at TIMESTAMPTZ,
+    discarded_at TIMESTAMPTZ
+);
+
+CREATE INDEX dead_letters_pending_idx ON dead_letters (created_at) WHERE redriven_at IS NULL AND discarded_at IS NULL;
+
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE dead_letters DROP COLUMN IF EXISTS group_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS deduplication_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS group_id;
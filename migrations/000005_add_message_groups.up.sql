@@ -0,0 +1,3 @@
+ALTER TABLE outbox ADD COLUMN group_id VARCHAR;
+ALTER TABLE outbox ADD COLUMN deduplication_id VARCHAR;
+ALTER TABLE dead_letters ADD COLUMN group_id VARCHAR;
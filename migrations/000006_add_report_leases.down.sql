@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS reports_lease_idx;
+ALTER TABLE reports DROP COLUMN IF EXISTS attempts;
+ALTER TABLE reports DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS worker_id;
+
+UPDATE reports SET started_at = created_at WHERE started_at IS NULL;
+ALTER TABLE reports ALTER COLUMN started_at SET NOT NULL;
+ALTER TABLE reports ALTER COLUMN started_at SET DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,10 @@
+ALTER TABLE reports ALTER COLUMN started_at DROP DEFAULT;
+ALTER TABLE reports ALTER COLUMN started_at DROP NOT NULL;
+-- started_at used to be set on insert, so unfinished reports were never claimed
+UPDATE reports SET started_at = NULL WHERE completed_at IS NULL AND failed_at IS NULL;
+
+ALTER TABLE reports ADD COLUMN worker_id VARCHAR;
+ALTER TABLE reports ADD COLUMN lease_expires_at TIMESTAMPTZ;
+ALTER TABLE reports ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX reports_lease_idx ON reports (lease_expires_at) WHERE lease_expires_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS report_events;
+ALTER TABLE reports DROP COLUMN IF EXISTS status;
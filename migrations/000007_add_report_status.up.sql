@@ -0,0 +1,23 @@
+ALTER TABLE reports ADD COLUMN status VARCHAR NOT NULL DEFAULT 'requested'
+    CHECK (status IN ('requested', 'queued', 'processing', 'completed', 'failed', 'cancelled', 'expired'));
+
+UPDATE reports SET status = CASE
+    WHEN completed_at IS NOT NULL THEN 'completed'
+    WHEN failed_at IS NOT NULL THEN 'failed'
+    WHEN lease_expires_at IS NOT NULL THEN 'processing'
+    ELSE 'queued'
+END;
+
+CREATE TABLE report_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    report_id UUID NOT NULL,
+    from_status VARCHAR,
+    to_status VARCHAR NOT NULL,
+    actor VARCHAR NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id, report_id) REFERENCES reports (user_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX report_events_report_idx ON report_events (user_id, report_id, id);
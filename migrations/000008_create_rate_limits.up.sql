@@ -0,0 +1,8 @@
+CREATE TABLE rate_limits (
+    key VARCHAR PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    full_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX rate_limits_full_at_idx ON rate_limits (full_at);
@@ -0,0 +1,11 @@
+CREATE TABLE signin_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(320) NOT NULL,
+    ip VARCHAR NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    outcome VARCHAR NOT NULL CHECK (outcome IN ('succeeded', 'failed', 'throttled', 'locked')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX signin_attempts_email_idx ON signin_attempts (email, created_at);
+CREATE INDEX signin_attempts_ip_idx ON signin_attempts (ip, created_at);
@@ -0,0 +1,21 @@
+-- signup now stores emails lowercased, older rows may differ only by case and
+-- have to be merged by hand before the unique index can be built
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(email, ', ' ORDER BY email) INTO duplicates
+    FROM (
+        SELECT lower(trim(email)) AS email FROM users
+        GROUP BY lower(trim(email))
+        HAVING count(*) > 1
+    ) AS duplicated;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users share an email address ignoring case and whitespace, merge or rename them first: %', duplicates;
+    END IF;
+END $$;
+
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX users_email_lower_idx ON users (lower(email));
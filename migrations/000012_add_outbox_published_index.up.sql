@@ -0,0 +1 @@
+CREATE INDEX outbox_published_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
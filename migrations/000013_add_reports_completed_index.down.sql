@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS reports_expired_output_idx;
+DROP INDEX IF EXISTS reports_completed_idx;
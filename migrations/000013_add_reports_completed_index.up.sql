@@ -0,0 +1,2 @@
+CREATE INDEX reports_completed_idx ON reports (completed_at) WHERE status = 'completed';
+CREATE INDEX reports_expired_output_idx ON reports (completed_at) WHERE status = 'expired' AND output_file_path IS NOT NULL;
@@ -0,0 +1,144 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const metaSuffix = ".meta.json"
+
+// FileStore keeps objects under a local directory with their metadata in a
+// json sidecar file. Signed urls point at the api server, which verifies them
+// and streams the file.
+type FileStore struct {
+	root   string
+	signer *UrlSigner
+}
+
+func NewFileStore(root string, signer *UrlSigner) (*FileStore, error) {
+	if root == "" {
+		return nil, errors.New("object store directory is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create object store directory %s: %w", root, err)
+	}
+	return &FileStore{root: root, signer: signer}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(CleanKey(key)))
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read body for %s: %w", key, err)
+	}
+
+	info := ObjectInfo{
+		Key:                CleanKey(key),
+		Size:               int64(len(data)),
+		ContentType:        opts.ContentType,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentDisposition: opts.ContentDisposition,
+		Metadata:           opts.Metadata,
+		Tags:               opts.Tags,
+		LastModified:       time.Now().UTC(),
+	}
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata for %s: %w", key, err)
+	}
+
+	// write to temp files first so readers never observe a partial object
+	if err := writeFileAtomic(p, data); err != nil {
+		return fmt.Errorf("failed to write object %s: %w", key, err)
+	}
+	if err := writeFileAtomic(p+metaSuffix, meta); err != nil {
+		return fmt.Errorf("failed to write metadata for %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (*Object, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object %s: %w", key, fsError(err))
+	}
+	return &Object{ObjectInfo: *info, Body: f}, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	p := s.path(key)
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	if err := os.Remove(p + metaSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata for %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	meta, err := os.ReadFile(s.path(key) + metaSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, fsError(err))
+	}
+
+	var info ObjectInfo
+	if err := json.Unmarshal(meta, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata for %s: %w", key, err)
+	}
+	return &info, nil
+}
+
+func (s *FileStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return s.signer.Sign(key, ttl), nil
+}
+
+func (s *FileStore) VerifySignedURL(key string, query url.Values) error {
+	return s.signer.Verify(key, query)
+}
+
+func writeFileAtomic(p string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func fsError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	info ObjectInfo
+	data []byte
+}
+
+// MemoryStore keeps objects in process memory. It is meant for tests and for
+// running the api server and worker in a single process during development.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	signer  *UrlSigner
+}
+
+func NewMemoryStore(signer *UrlSigner) *MemoryStore {
+	return &MemoryStore{
+		objects: map[string]memoryObject{},
+		signer:  signer,
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read body for %s: %w", key, err)
+	}
+
+	key = CleanKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{
+		info: ObjectInfo{
+			Key:                key,
+			Size:               int64(len(data)),
+			ContentType:        opts.ContentType,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentDisposition: opts.ContentDisposition,
+			Metadata:           maps.Clone(opts.Metadata),
+			Tags:               maps.Clone(opts.Tags),
+			LastModified:       time.Now().UTC(),
+		},
+		data: data,
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[CleanKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, ErrNotFound)
+	}
+	return &Object{ObjectInfo: object.info, Body: io.NopCloser(bytes.NewReader(object.data))}, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, CleanKey(key))
+	return nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[CleanKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, ErrNotFound)
+	}
+	info := object.info
+	return &info, nil
+}
+
+func (s *MemoryStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return s.signer.Sign(key, ttl), nil
+}
+
+func (s *MemoryStore) VerifySignedURL(key string, query url.Values) error {
+	return s.signer.Verify(key, query)
+}
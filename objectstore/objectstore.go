@@ -0,0 +1,160 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-sqs/config"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	ErrNotFound         = errors.New("object not found")
+	ErrInvalidSignature = errors.New("invalid signed url")
+	ErrSignedURLExpired = errors.New("signed url expired")
+)
+
+type Backend string
+
+const (
+	Backend_S3         Backend = "s3"
+	Backend_Filesystem Backend = "fs"
+	Backend_Memory     Backend = "memory"
+)
+
+type PutOptions struct {
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	Metadata           map[string]string
+	Tags               map[string]string
+}
+
+type ObjectInfo struct {
+	Key                string            `json:"key"`
+	Size               int64             `json:"size"`
+	ContentType        string            `json:"content_type,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	LastModified       time.Time         `json:"last_modified"`
+}
+
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+}
+
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	Get(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// SignedURLVerifier is implemented by stores whose signed urls are served by
+// the api server itself rather than by the storage backend.
+type SignedURLVerifier interface {
+	VerifySignedURL(key string, query url.Values) error
+}
+
+// New builds the object store selected by OBJECT_STORE. The s3 clients are
+// only used by the s3 backend and may be nil otherwise.
+//
+// The memory backend is refused: the worker would upload into its own memory
+// and every download from the api server would miss. Tests and single process
+// setups share one NewMemoryStore instead.
+func New(conf *config.Config, s3Client *s3.Client, presignClient *s3.PresignClient) (ObjectStore, error) {
+	switch Backend(conf.ObjectStore) {
+	case Backend_S3, "":
+		return NewS3Store(s3Client, presignClient, conf.S3Bucket, S3Encryption(conf.ReportEncryption), conf.ReportKmsKeyId), nil
+	case Backend_Filesystem:
+		signer, err := newUrlSigner(conf)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileStore(conf.ObjectStoreDir, signer)
+	case Backend_Memory:
+		return nil, fmt.Errorf("object store %q cannot be shared between the api server and worker processes, use s3 or fs", conf.ObjectStore)
+	}
+	return nil, fmt.Errorf("unknown object store %q", conf.ObjectStore)
+}
+
+// CleanKey normalises a key so "/users/1/a.csv" and "users/1/a.csv" address
+// the same object and keys can never escape the store root.
+func CleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// UrlSigner produces and checks HMAC signed urls pointing at the api server's
+// object route.
+type UrlSigner struct {
+	baseUrl string
+	secret  []byte
+}
+
+func NewUrlSigner(baseUrl string, secret []byte) *UrlSigner {
+	return &UrlSigner{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		secret:  secret,
+	}
+}
+
+func newUrlSigner(conf *config.Config) (*UrlSigner, error) {
+	if conf.ObjectStoreSigningKey == "" {
+		return nil, errors.New("OBJECT_STORE_SIGNING_KEY is required for the fs object store")
+	}
+	return NewUrlSigner(conf.PublicUrl()+"/objects", []byte(conf.ObjectStoreSigningKey)), nil
+}
+
+func (s *UrlSigner) Sign(key string, ttl time.Duration) string {
+	key = CleanKey(key)
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(key, expires))
+
+	return s.baseUrl + "/" + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode()
+}
+
+func (s *UrlSigner) Verify(key string, query url.Values) error {
+	key = CleanKey(key)
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || expires == "" {
+		return ErrInvalidSignature
+	}
+
+	expected, _ := hex.DecodeString(s.signature(key, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrSignedURLExpired
+	}
+	return nil
+}
+
+func (s *UrlSigner) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package objectstore_test
+
+import (
+	"bytes"
+	"context"
+	"go-sqs/config"
+	"go-sqs/objectstore"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	signer := objectstore.NewUrlSigner("http://localhost:5000/objects", []byte("secret"))
+	testObjectStore(t, objectstore.NewMemoryStore(signer))
+}
+
+func TestSignedURLUsesPublicUrl(t *testing.T) {
+	store, err := objectstore.New(&config.Config{
+		ObjectStore:           "fs",
+		ObjectStoreDir:        t.TempDir(),
+		ObjectStoreSigningKey: "secret",
+		ApiServerHost:         "0.0.0.0",
+		ApiServerPort:         "5000",
+		ApiPublicUrl:          "https://api.example.com/",
+	}, nil, nil)
+	require.NoError(t, err)
+
+	signed, err := store.SignedURL(context.Background(), "users/1/a.csv", time.Minute)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(signed, "https://api.example.com/objects/users/1/a.csv?"), signed)
+}
+
+func TestNewRejectsMemoryBackend(t *testing.T) {
+	_, err := objectstore.New(&config.Config{
+		ObjectStore:           "memory",
+		ObjectStoreSigningKey: "secret",
+	}, nil, nil)
+	require.ErrorContains(t, err, "cannot be shared between the api server and worker processes")
+}
+
+func TestFileStore(t *testing.T) {
+	signer := objectstore.NewUrlSigner("http://localhost:5000/objects", []byte("secret"))
+	fileStore, err := objectstore.NewFileStore(t.TempDir(), signer)
+	require.NoError(t, err)
+	testObjectStore(t, fileStore)
+}
+
+func testObjectStore(t *testing.T, objectStore objectstore.ObjectStore) {
+	ctx := context.Background()
+	key := "/users/1/report/2.csv.gz"
+
+	_, err := objectStore.Stat(ctx, key)
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+
+	err = objectStore.Put(ctx, key, bytes.NewReader([]byte("a,b\n1,2\n")), objectstore.PutOptions{
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"report-id": "2"},
+		Tags:            map[string]string{"user-id": "1"},
+	})
+	require.NoError(t, err)
+
+	info, err := objectStore.Stat(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), info.Size)
+	require.Equal(t, "text/csv", info.ContentType)
+	require.Equal(t, "gzip", info.ContentEncoding)
+	require.Equal(t, "2", info.Metadata["report-id"])
+
+	object, err := objectStore.Get(ctx, "users/1/report/2.csv.gz")
+	require.NoError(t, err)
+	body, err := io.ReadAll(object.Body)
+	require.NoError(t, object.Body.Close())
+	require.NoError(t, err)
+	require.Equal(t, "a,b\n1,2\n", string(body))
+
+	signedUrl, err := objectStore.SignedURL(ctx, key, time.Minute)
+	require.NoError(t, err)
+	parsed, err := url.Parse(signedUrl)
+	require.NoError(t, err)
+	signedKey := strings.TrimPrefix(parsed.Path, "/objects/")
+	require.Equal(t, "users/1/report/2.csv.gz", signedKey)
+
+	verifier, ok := objectStore.(objectstore.SignedURLVerifier)
+	require.True(t, ok)
+	require.NoError(t, verifier.VerifySignedURL(signedKey, parsed.Query()))
+	require.ErrorIs(t, verifier.VerifySignedURL("users/1/report/3.csv.gz", parsed.Query()), objectstore.ErrInvalidSignature)
+
+	expiredUrl, err := objectStore.SignedURL(ctx, key, -time.Minute)
+	require.NoError(t, err)
+	parsed, err = url.Parse(expiredUrl)
+	require.NoError(t, err)
+	require.ErrorIs(t, verifier.VerifySignedURL(signedKey, parsed.Query()), objectstore.ErrSignedURLExpired)
+
+	require.NoError(t, objectStore.Delete(ctx, key))
+	_, err = objectStore.Get(ctx, key)
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+	require.NoError(t, objectStore.Delete(ctx, key))
+}
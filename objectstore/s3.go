@@ -0,0 +1,160 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Encryption is the server side encryption applied to every upload. The
+// values match the REPORT_ENCRYPTION settings; anything other than sse-s3 and
+// sse-kms leaves encryption to the bucket defaults.
+type S3Encryption string
+
+const (
+	S3Encryption_SseS3  S3Encryption = "sse-s3"
+	S3Encryption_SseKms S3Encryption = "sse-kms"
+)
+
+type S3Store struct {
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+	encryption    S3Encryption
+	kmsKeyId      string
+}
+
+func NewS3Store(client *s3.Client, presignClient *s3.PresignClient, bucket string, encryption S3Encryption, kmsKeyId string) *S3Store {
+	return &S3Store{
+		client:        client,
+		presignClient: presignClient,
+		bucket:        bucket,
+		encryption:    encryption,
+		kmsKeyId:      kmsKeyId,
+	}
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		Body:     body,
+		Metadata: opts.Metadata,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if len(opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	switch s.encryption {
+	case S3Encryption_SseS3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case S3Encryption_SseKms:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(s.kmsKeyId)
+	}
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (*Object, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, s3Error(err))
+	}
+
+	return &Object{
+		ObjectInfo: ObjectInfo{
+			Key:                key,
+			Size:               aws.ToInt64(output.ContentLength),
+			ContentType:        aws.ToString(output.ContentType),
+			ContentEncoding:    aws.ToString(output.ContentEncoding),
+			ContentDisposition: aws.ToString(output.ContentDisposition),
+			Metadata:           output.Metadata,
+			LastModified:       aws.ToTime(output.LastModified),
+		},
+		Body: output.Body,
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, s3Error(err))
+	}
+
+	return &ObjectInfo{
+		Key:                key,
+		Size:               aws.ToInt64(output.ContentLength),
+		ContentType:        aws.ToString(output.ContentType),
+		ContentEncoding:    aws.ToString(output.ContentEncoding),
+		ContentDisposition: aws.ToString(output.ContentDisposition),
+		Metadata:           output.Metadata,
+		LastModified:       aws.ToTime(output.LastModified),
+	}, nil
+}
+
+func (s *S3Store) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	signed, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, func(options *s3.PresignOptions) {
+		options.Expires = ttl
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object %s: %w", key, err)
+	}
+	return signed.URL, nil
+}
+
+// s3Error maps missing keys onto ErrNotFound. HeadObject has no body to carry
+// a typed NoSuchKey, so the generic api error code is checked as well.
+func s3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	var apiErr smithy.APIError
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) ||
+		(errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey")) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
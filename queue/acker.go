@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const maxAckAttempts = 3
+
+// AckFailure is a delivery a batch ack could not delete. Retryable failures
+// are transient on the queue side; the others, such as an expired receipt,
+// will not succeed on a second attempt.
+type AckFailure struct {
+	Delivery  Delivery
+	Err       error
+	Retryable bool
+}
+
+// BatchAcker is implemented by queues that can delete several deliveries in
+// one call. The returned error means the whole call failed.
+type BatchAcker interface {
+	AckBatch(ctx context.Context, ds []Delivery) ([]AckFailure, error)
+}
+
+type pendingAck struct {
+	delivery Delivery
+	attempts int
+}
+
+// Acker collects processed deliveries and acks them in batches, flushing when
+// a batch is full or the flush interval has passed. Queues without batch
+// support are acked one delivery at a time.
+type Acker struct {
+	jobQueue JobQueue
+	maxBatch int
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	pending []pendingAck
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewAcker(jobQueue JobQueue, maxBatch int, interval time.Duration, logger *slog.Logger) *Acker {
+	return &Acker{
+		jobQueue: jobQueue,
+		maxBatch: maxBatch,
+		interval: interval,
+		logger:   logger,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the flush loop until Close is called. Flushes use ctx, which
+// should stay valid until Close returns.
+func (a *Acker) Start(ctx context.Context) {
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.stop:
+				// terminates because every delivery is retried at most
+				// maxAckAttempts times
+				for a.flush(ctx) > 0 {
+				}
+				return
+			case <-ticker.C:
+				a.flush(ctx)
+			case <-a.full:
+				a.flush(ctx)
+			}
+		}
+	}()
+}
+
+func (a *Acker) Ack(d Delivery) {
+	a.mu.Lock()
+	a.pending = append(a.pending, pendingAck{delivery: d})
+	full := len(a.pending) >= a.maxBatch
+	a.mu.Unlock()
+
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close flushes everything still pending and stops the flush loop.
+func (a *Acker) Close() {
+	close(a.stop)
+	<-a.done
+}
+
+// flush sends one batch and returns how many acks are still pending.
+func (a *Acker) flush(ctx context.Context) int {
+	a.mu.Lock()
+	n := min(len(a.pending), a.maxBatch)
+	batch := a.pending[:n:n]
+	a.pending = a.pending[n:]
+	a.mu.Unlock()
+
+	if len(batch) == 0 {
+		return 0
+	}
+
+	var retry []pendingAck
+	for _, failure := range a.ackBatch(ctx, batch) {
+		ack := pendingAck{delivery: failure.Delivery}
+		for _, b := range batch {
+			if b.delivery.Receipt == failure.Delivery.Receipt {
+				ack.attempts = b.attempts + 1
+			}
+		}
+
+		if !failure.Retryable || ack.attempts >= maxAckAttempts {
+			// the message becomes visible again and is redelivered, which
+			// the builder tolerates
+			a.logger.Error("failed to ack message", "error", failure.Err, "messageId", failure.Delivery.Id, "attempts", ack.attempts)
+			continue
+		}
+		retry = append(retry, ack)
+	}
+
+	a.mu.Lock()
+	a.pending = append(a.pending, retry...)
+	remaining := len(a.pending)
+	a.mu.Unlock()
+
+	return remaining
+}
+
+func (a *Acker) ackBatch(ctx context.Context, batch []pendingAck) []AckFailure {
+	deliveries := make([]Delivery, 0, len(batch))
+	for _, ack := range batch {
+		deliveries = append(deliveries, ack.delivery)
+	}
+
+	if batchAcker, ok := a.jobQueue.(BatchAcker); ok {
+		failures, err := batchAcker.AckBatch(ctx, deliveries)
+		if err == nil {
+			return failures
+		}
+		failures = failures[:0]
+		for _, d := range deliveries {
+			failures = append(failures, AckFailure{Delivery: d, Err: err, Retryable: true})
+		}
+		return failures
+	}
+
+	var failures []AckFailure
+	for _, d := range deliveries {
+		if err := a.jobQueue.Ack(ctx, d); err != nil {
+			failures = append(failures, AckFailure{Delivery: d, Err: err, Retryable: !isStale(err)})
+		}
+	}
+	return failures
+}
+
+func isStale(err error) bool {
+	return errors.Is(err, ErrStaleReceipt)
+}
@@ -0,0 +1,68 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"go-sqs/queue"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// batchQueue fails the first ack of every delivery whose body is "flaky" and
+// permanently rejects bodies of "stale".
+type batchQueue struct {
+	queue.JobQueue
+	mu      sync.Mutex
+	calls   [][]string
+	flakies map[string]bool
+}
+
+func (q *batchQueue) AckBatch(ctx context.Context, ds []queue.Delivery) ([]queue.AckFailure, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	var failures []queue.AckFailure
+	for _, d := range ds {
+		ids = append(ids, d.Id)
+		switch string(d.Body) {
+		case "flaky":
+			if !q.flakies[d.Id] {
+				q.flakies[d.Id] = true
+				failures = append(failures, queue.AckFailure{Delivery: d, Err: errors.New("throttled"), Retryable: true})
+			}
+		case "stale":
+			failures = append(failures, queue.AckFailure{Delivery: d, Err: queue.ErrStaleReceipt})
+		}
+	}
+	q.calls = append(q.calls, ids)
+	return failures, nil
+}
+
+func TestAcker(t *testing.T) {
+	jobQueue := &batchQueue{flakies: map[string]bool{}}
+	acker := queue.NewAcker(jobQueue, 3, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	acker.Start(context.Background())
+
+	acker.Ack(queue.Delivery{Id: "1", Receipt: "r1"})
+	acker.Ack(queue.Delivery{Id: "2", Receipt: "r2", Body: []byte("flaky")})
+	acker.Ack(queue.Delivery{Id: "3", Receipt: "r3", Body: []byte("stale")})
+
+	// a full batch is flushed without waiting for the interval
+	require.Eventually(t, func() bool {
+		jobQueue.mu.Lock()
+		defer jobQueue.mu.Unlock()
+		return len(jobQueue.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	acker.Ack(queue.Delivery{Id: "4", Receipt: "r4"})
+	acker.Close()
+
+	// the retryable failure is retried alongside the pending ack on shutdown
+	require.Equal(t, [][]string{{"1", "2", "3"}, {"2", "4"}}, jobQueue.calls)
+}
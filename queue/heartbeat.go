@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StartHeartbeat keeps a delivery invisible while it is being processed by
+// extending its visibility to timeout every interval. The returned stop
+// function ends the heartbeat and waits for it to exit, so calling it before
+// Ack or Nack guarantees no extension races with them. onError is called for
+// failed extensions; a stale receipt ends the heartbeat since the delivery is
+// lost anyway.
+func StartHeartbeat(ctx context.Context, q JobQueue, d Delivery, interval time.Duration, timeout time.Duration, onError func(error)) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := q.ExtendVisibility(ctx, d, timeout); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					onError(err)
+					if errors.Is(err, ErrStaleReceipt) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
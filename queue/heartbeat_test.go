@@ -0,0 +1,33 @@
+package queue_test
+
+import (
+	"context"
+	"go-sqs/queue"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	jobQueue := queue.NewMemoryQueue(50*time.Millisecond, 20*time.Millisecond)
+	require.NoError(t, jobQueue.Publish(ctx, queue.Message{Body: []byte("slow build")}))
+
+	deliveries, err := jobQueue.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	stop := queue.StartHeartbeat(ctx, jobQueue, deliveries[0], 10*time.Millisecond, 50*time.Millisecond, func(err error) {
+		t.Errorf("unexpected heartbeat error: %v", err)
+	})
+
+	// well past the visibility timeout the message is still in flight
+	time.Sleep(150 * time.Millisecond)
+	redelivered, err := jobQueue.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Empty(t, redelivered)
+
+	stop()
+	require.NoError(t, jobQueue.Ack(ctx, deliveries[0]))
+}
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const memoryQueueSize = 1024
+
+type memoryMessage struct {
+	id           string
+	msg          Message
+	receiveCount int
+	timer        *time.Timer
+}
+
+// MemoryQueue is a channel backed queue with SQS style visibility timeouts.
+// It only works when the producer and the worker share a process, which makes
+// it useful for tests and single binary development setups.
+type MemoryQueue struct {
+	visibility time.Duration
+	wait       time.Duration
+	ready      chan *memoryMessage
+
+	mu       sync.Mutex
+	nextId   int64
+	inflight map[string]*memoryMessage
+}
+
+func NewMemoryQueue(visibility time.Duration, wait time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		visibility: visibility,
+		wait:       wait,
+		ready:      make(chan *memoryMessage, memoryQueueSize),
+		inflight:   map[string]*memoryMessage{},
+	}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, msg Message) error {
+	q.mu.Lock()
+	q.nextId++
+	id := strconv.FormatInt(q.nextId, 10)
+	q.mu.Unlock()
+
+	m := &memoryMessage{
+		id: id,
+		msg: Message{
+			Body:       msg.Body,
+			Attributes: maps.Clone(msg.Attributes),
+			GroupId:    msg.GroupId,
+		},
+	}
+	if msg.Delay > 0 {
+		time.AfterFunc(msg.Delay, func() { q.ready <- m })
+		return nil
+	}
+	select {
+	case q.ready <- m:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to publish message: %w", ctx.Err())
+	}
+}
+
+func (q *MemoryQueue) Receive(ctx context.Context, max int) ([]Delivery, error) {
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+
+	var messages []*memoryMessage
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil
+	case m := <-q.ready:
+		messages = append(messages, m)
+	}
+
+drain:
+	for len(messages) < max {
+		select {
+		case m := <-q.ready:
+			messages = append(messages, m)
+		default:
+			break drain
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	deliveries := make([]Delivery, 0, len(messages))
+	for _, m := range messages {
+		receipt := uuid.NewString()
+		m.receiveCount++
+		m.timer = time.AfterFunc(q.visibility, func() { q.expire(receipt) })
+		q.inflight[receipt] = m
+
+		deliveries = append(deliveries, Delivery{
+			Id:           m.id,
+			Body:         m.msg.Body,
+			Attributes:   maps.Clone(m.msg.Attributes),
+			ReceiveCount: m.receiveCount,
+			Receipt:      receipt,
+			GroupId:      m.msg.GroupId,
+		})
+	}
+	return deliveries, nil
+}
+
+func (q *MemoryQueue) Depth(ctx context.Context) (int, error) {
+	return len(q.ready), nil
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, d Delivery) error {
+	_, err := q.take(d)
+	return err
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, d Delivery, delay time.Duration) error {
+	m, err := q.take(d)
+	if err != nil {
+		return err
+	}
+	time.AfterFunc(delay, func() { q.ready <- m })
+	return nil
+}
+
+func (q *MemoryQueue) ExtendVisibility(ctx context.Context, d Delivery, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.inflight[d.Receipt]
+	if !ok {
+		return fmt.Errorf("message %s: %w", d.Id, ErrStaleReceipt)
+	}
+	m.timer.Reset(timeout)
+	return nil
+}
+
+// take removes an in-flight message so its visibility timer can no longer
+// return it to the queue.
+func (q *MemoryQueue) take(d Delivery) (*memoryMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.inflight[d.Receipt]
+	if !ok {
+		return nil, fmt.Errorf("message %s: %w", d.Id, ErrStaleReceipt)
+	}
+	m.timer.Stop()
+	delete(q.inflight, d.Receipt)
+	return m, nil
+}
+
+func (q *MemoryQueue) expire(receipt string) {
+	q.mu.Lock()
+	m, ok := q.inflight[receipt]
+	delete(q.inflight, receipt)
+	q.mu.Unlock()
+
+	if ok {
+		q.ready <- m
+	}
+}
@@ -0,0 +1,70 @@
+package queue_test
+
+import (
+	"context"
+	"go-sqs/config"
+	"go-sqs/queue"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	ctx := context.Background()
+	jobQueue := queue.NewMemoryQueue(50*time.Millisecond, 20*time.Millisecond)
+
+	deliveries, err := jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+
+	require.NoError(t, jobQueue.Publish(ctx, queue.Message{Body: []byte("one"), Attributes: map[string]string{"type": "report"}}))
+	require.NoError(t, jobQueue.Publish(ctx, queue.Message{Body: []byte("two"), GroupId: "user-1"}))
+
+	deliveries, err = jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, "one", string(deliveries[0].Body))
+	require.Equal(t, "report", deliveries[0].Attributes["type"])
+	require.Equal(t, 1, deliveries[0].ReceiveCount)
+	require.Equal(t, "user-1", deliveries[1].GroupId)
+
+	require.NoError(t, jobQueue.Ack(ctx, deliveries[0]))
+	require.ErrorIs(t, jobQueue.Ack(ctx, deliveries[0]), queue.ErrStaleReceipt)
+
+	// an unacked delivery comes back once its visibility times out
+	redelivered, err := jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, redelivered)
+	time.Sleep(60 * time.Millisecond)
+	redelivered, err = jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	require.Equal(t, "two", string(redelivered[0].Body))
+	require.Equal(t, 2, redelivered[0].ReceiveCount)
+	require.ErrorIs(t, jobQueue.Ack(ctx, deliveries[1]), queue.ErrStaleReceipt)
+
+	require.NoError(t, jobQueue.ExtendVisibility(ctx, redelivered[0], time.Second))
+	require.NoError(t, jobQueue.Nack(ctx, redelivered[0], 0))
+	redelivered, err = jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, redelivered, 1)
+	require.Equal(t, 3, redelivered[0].ReceiveCount)
+	require.NoError(t, jobQueue.Ack(ctx, redelivered[0]))
+
+	// a delayed message is only received once its delay has passed
+	require.NoError(t, jobQueue.Publish(ctx, queue.Message{Body: []byte("later"), Delay: 40 * time.Millisecond}))
+	delayed, err := jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, delayed)
+	time.Sleep(30 * time.Millisecond)
+	delayed, err = jobQueue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, delayed, 1)
+	require.Equal(t, 1, delayed[0].ReceiveCount)
+}
+
+func TestNewRejectsMemoryBackend(t *testing.T) {
+	_, err := queue.New(&config.Config{JobQueue: "memory"}, "reports", nil, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-sqs/store"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// pollInterval is how often an empty postgres queue is checked again while
+// Receive is long polling.
+const pollInterval = time.Second
+
+// PostgresQueue stores jobs in the jobs table. Receivers claim rows with
+// FOR UPDATE SKIP LOCKED, which lets small deployments run without SQS.
+type PostgresQueue struct {
+	jobStore   *store.JobStore
+	queueName  string
+	visibility time.Duration
+}
+
+func NewPostgresQueue(jobStore *store.JobStore, queueName string, visibility time.Duration) *PostgresQueue {
+	return &PostgresQueue{
+		jobStore:   jobStore,
+		queueName:  queueName,
+		visibility: visibility,
+	}
+}
+
+func (q *PostgresQueue) Publish(ctx context.Context, msg Message) error {
+	_, err := q.jobStore.Enqueue(ctx, q.queueName, msg.Body, msg.Attributes, msg.Delay)
+	return err
+}
+
+func (q *PostgresQueue) Receive(ctx context.Context, max int) ([]Delivery, error) {
+	deadline := time.Now().Add(receiveWait)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		jobs, err := q.jobStore.Claim(ctx, q.queueName, max, q.visibility)
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) > 0 || !time.Now().Before(deadline) {
+			deliveries := make([]Delivery, 0, len(jobs))
+			for _, job := range jobs {
+				deliveries = append(deliveries, Delivery{
+					Id:           strconv.FormatInt(job.Id, 10),
+					Body:         job.Body,
+					Attributes:   job.Attributes,
+					ReceiveCount: job.ReceiveCount,
+					Receipt:      job.Receipt.String(),
+				})
+			}
+			return deliveries, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *PostgresQueue) Depth(ctx context.Context) (int, error) {
+	return q.jobStore.CountVisible(ctx, q.queueName)
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, d Delivery) error {
+	id, receipt, err := parseDelivery(d)
+	if err != nil {
+		return err
+	}
+	return jobError(q.jobStore.Delete(ctx, id, receipt))
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, d Delivery, delay time.Duration) error {
+	return q.ExtendVisibility(ctx, d, delay)
+}
+
+func (q *PostgresQueue) ExtendVisibility(ctx context.Context, d Delivery, timeout time.Duration) error {
+	id, receipt, err := parseDelivery(d)
+	if err != nil {
+		return err
+	}
+	return jobError(q.jobStore.SetVisibility(ctx, id, receipt, timeout))
+}
+
+func parseDelivery(d Delivery) (int64, uuid.UUID, error) {
+	id, err := strconv.ParseInt(d.Id, 10, 64)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("invalid job id %q: %w", d.Id, err)
+	}
+	receipt, err := uuid.Parse(d.Receipt)
+	if err != nil {
+		return 0, uuid.Nil, fmt.Errorf("invalid job receipt %q: %w", d.Receipt, err)
+	}
+	return id, receipt, nil
+}
+
+func jobError(err error) error {
+	if errors.Is(err, store.ErrStaleReceipt) {
+		return fmt.Errorf("%w: %w", ErrStaleReceipt, err)
+	}
+	return err
+}
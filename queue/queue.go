@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-sqs/config"
+	"go-sqs/store"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+var ErrStaleReceipt = errors.New("delivery receipt is no longer valid")
+
+type Backend string
+
+const (
+	Backend_Sqs      Backend = "sqs"
+	Backend_Postgres Backend = "postgres"
+	Backend_Memory   Backend = "memory"
+)
+
+// receiveWait is how long Receive long polls before returning an empty batch.
+const receiveWait = 10 * time.Second
+
+// Message is published to a queue. GroupId and DeduplicationId are only
+// honoured by SQS FIFO queues: messages sharing a group are delivered in
+// order, and a message repeating a deduplication id within five minutes of
+// the first is dropped. Delay hides a new message from receivers for a while,
+// FIFO queues only support a delay for the whole queue and ignore it.
+type Message struct {
+	Body            []byte
+	Attributes      map[string]string
+	GroupId         string
+	DeduplicationId string
+	Delay           time.Duration
+}
+
+// Delivery is a message handed to a consumer. It stays invisible to other
+// consumers until it is acked, nacked or its visibility timeout runs out.
+type Delivery struct {
+	Id           string
+	Body         []byte
+	Attributes   map[string]string
+	ReceiveCount int
+	Receipt      string
+	// GroupId is set for messages received from a FIFO queue. Deliveries of
+	// one group must be processed one at a time, in the order received.
+	GroupId string
+}
+
+type JobQueue interface {
+	Publish(ctx context.Context, msg Message) error
+	Receive(ctx context.Context, max int) ([]Delivery, error)
+	Ack(ctx context.Context, d Delivery) error
+	Nack(ctx context.Context, d Delivery, delay time.Duration) error
+	ExtendVisibility(ctx context.Context, d Delivery, timeout time.Duration) error
+}
+
+// DepthReporter is implemented by queues that can report roughly how many
+// messages are waiting to be received.
+type DepthReporter interface {
+	Depth(ctx context.Context) (int, error)
+}
+
+// New builds the queue named name on the backend selected by JOB_QUEUE. The
+// sqs client and job store are only used by their respective backends.
+//
+// The memory backend is refused: the api server and the worker run as
+// separate processes, and each would publish to and consume from a queue of
+// its own. Tests and single process setups share one NewMemoryQueue instead.
+func New(conf *config.Config, name string, sqsClient *sqs.Client, jobStore *store.JobStore) (JobQueue, error) {
+	switch Backend(conf.JobQueue) {
+	case Backend_Sqs, "":
+		return NewSqsQueue(sqsClient, name), nil
+	case Backend_Postgres:
+		return NewPostgresQueue(jobStore, name, conf.JobVisibilityTimeout), nil
+	case Backend_Memory:
+		return nil, fmt.Errorf("job queue %q cannot be shared between the api server and worker processes, use sqs or postgres", conf.JobQueue)
+	}
+	return nil, fmt.Errorf("unknown job queue %q", conf.JobQueue)
+}
@@ -0,0 +1,233 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxDelaySeconds is the longest delay SQS accepts for a message.
+const maxDelaySeconds = 900
+
+// SqsQueue publishes to and receives from an SQS queue. Queues whose name ends
+// in .fifo are FIFO queues and get the message group and deduplication ids.
+type SqsQueue struct {
+	client    *sqs.Client
+	queueName string
+	fifo      bool
+
+	mu       sync.Mutex
+	queueUrl *string
+}
+
+func NewSqsQueue(client *sqs.Client, queueName string) *SqsQueue {
+	return &SqsQueue{
+		client:    client,
+		queueName: queueName,
+		fifo:      strings.HasSuffix(queueName, ".fifo"),
+	}
+}
+
+// url resolves the queue url on first use and caches it, so constructing a
+// queue never needs network access.
+func (q *SqsQueue) url(ctx context.Context) (*string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queueUrl != nil {
+		return q.queueUrl, nil
+	}
+
+	output, err := q.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(q.queueName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SQS queue URL for %s: %w", q.queueName, err)
+	}
+	q.queueUrl = output.QueueUrl
+	return q.queueUrl, nil
+}
+
+func (q *SqsQueue) Publish(ctx context.Context, msg Message) error {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return err
+	}
+
+	attributes := make(map[string]types.MessageAttributeValue, len(msg.Attributes))
+	for k, v := range msg.Attributes {
+		attributes[k] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:          queueUrl,
+		MessageBody:       aws.String(string(msg.Body)),
+		MessageAttributes: attributes,
+	}
+	if q.fifo {
+		if msg.GroupId == "" || msg.DeduplicationId == "" {
+			return fmt.Errorf("message for FIFO queue %s needs a group and deduplication id", q.queueName)
+		}
+		input.MessageGroupId = aws.String(msg.GroupId)
+		input.MessageDeduplicationId = aws.String(msg.DeduplicationId)
+	} else if msg.Delay > 0 {
+		input.DelaySeconds = int32(min(math.Ceil(msg.Delay.Seconds()), maxDelaySeconds))
+	}
+
+	if _, err := q.client.SendMessage(ctx, input); err != nil {
+		return fmt.Errorf("failed to send message to %s: %w", q.queueName, err)
+	}
+	return nil
+}
+
+func (q *SqsQueue) Receive(ctx context.Context, max int) ([]Delivery, error) {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueUrl,
+		MaxNumberOfMessages: int32(min(max, 10)),
+		WaitTimeSeconds:     int32(receiveWait / time.Second),
+		MessageAttributeNames: []string{
+			"All",
+		},
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameApproximateReceiveCount,
+			types.MessageSystemAttributeNameMessageGroupId,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive messages from %s: %w", q.queueName, err)
+	}
+
+	deliveries := make([]Delivery, 0, len(output.Messages))
+	for _, message := range output.Messages {
+		attributes := make(map[string]string, len(message.MessageAttributes))
+		for k, v := range message.MessageAttributes {
+			if v.StringValue != nil {
+				attributes[k] = *v.StringValue
+			}
+		}
+		receiveCount, _ := strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+
+		deliveries = append(deliveries, Delivery{
+			Id:           aws.ToString(message.MessageId),
+			Body:         []byte(aws.ToString(message.Body)),
+			Attributes:   attributes,
+			ReceiveCount: receiveCount,
+			Receipt:      aws.ToString(message.ReceiptHandle),
+			GroupId:      message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		})
+	}
+	return deliveries, nil
+}
+
+func (q *SqsQueue) Ack(ctx context.Context, d Delivery) error {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      queueUrl,
+		ReceiptHandle: aws.String(d.Receipt),
+	}); err != nil {
+		return fmt.Errorf("failed to delete message %s from %s: %w", d.Id, q.queueName, err)
+	}
+	return nil
+}
+
+// AckBatch deletes up to ten deliveries with a single DeleteMessageBatch call.
+// Entries SQS blames on the sender, such as expired receipt handles, are
+// reported as not retryable.
+func (q *SqsQueue) AckBatch(ctx context.Context, ds []Delivery) ([]AckFailure, error) {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]types.DeleteMessageBatchRequestEntry, 0, len(ds))
+	for i, d := range ds {
+		entries = append(entries, types.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(d.Receipt),
+		})
+	}
+
+	output, err := q.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: queueUrl,
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message batch from %s: %w", q.queueName, err)
+	}
+
+	failures := make([]AckFailure, 0, len(output.Failed))
+	for _, failed := range output.Failed {
+		i, err := strconv.Atoi(aws.ToString(failed.Id))
+		if err != nil || i < 0 || i >= len(ds) {
+			continue
+		}
+		failures = append(failures, AckFailure{
+			Delivery:  ds[i],
+			Err:       fmt.Errorf("failed to delete message %s from %s: %s: %s", ds[i].Id, q.queueName, aws.ToString(failed.Code), aws.ToString(failed.Message)),
+			Retryable: !failed.SenderFault,
+		})
+	}
+	return failures, nil
+}
+
+func (q *SqsQueue) Depth(ctx context.Context) (int, error) {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: queueUrl,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get attributes of %s: %w", q.queueName, err)
+	}
+
+	depth, err := strconv.Atoi(output.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+	if err != nil {
+		return 0, fmt.Errorf("invalid message count for %s: %w", q.queueName, err)
+	}
+	return depth, nil
+}
+
+func (q *SqsQueue) Nack(ctx context.Context, d Delivery, delay time.Duration) error {
+	return q.ExtendVisibility(ctx, d, delay)
+}
+
+func (q *SqsQueue) ExtendVisibility(ctx context.Context, d Delivery, timeout time.Duration) error {
+	queueUrl, err := q.url(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          queueUrl,
+		ReceiptHandle:     aws.String(d.Receipt),
+		VisibilityTimeout: int32(timeout / time.Second),
+	}); err != nil {
+		return fmt.Errorf("failed to change visibility of message %s on %s: %w", d.Id, q.queueName, err)
+	}
+	return nil
+}
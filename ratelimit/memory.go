@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"go-sqs/config"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemoryLimiter keeps buckets in process, so each api instance enforces the
+// limits on its own.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit config.RateLimit) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		l.buckets[key] = b
+	}
+
+	tokens, result := take(b.tokens, now.Sub(b.updatedAt), limit)
+	b.tokens, b.updatedAt, b.fullAt = tokens, now, now.Add(result.Reset)
+	return result, nil
+}
+
+func (l *MemoryLimiter) Prune(ctx context.Context) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pruned := 0
+	for key, b := range l.buckets {
+		if !b.fullAt.After(now) {
+			delete(l.buckets, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,45 @@
+package ratelimit_test
+
+import (
+	"context"
+	"go-sqs/config"
+	"go-sqs/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := ratelimit.NewMemoryLimiter()
+	limit := config.RateLimit{Requests: 2, Per: 200 * time.Millisecond}
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		result, err := limiter.Allow(ctx, "user-1", limit)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		require.Equal(t, 2, result.Limit)
+		require.Equal(t, remaining, result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, "user-1", limit)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Greater(t, result.RetryAfter, time.Duration(0))
+	require.LessOrEqual(t, result.RetryAfter, 100*time.Millisecond)
+
+	// other keys have buckets of their own
+	result, err = limiter.Allow(ctx, "user-2", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	// a token is back after Per / Requests, full buckets are pruned
+	time.Sleep(250 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "user-1", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	pruned, err := limiter.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"context"
+	"go-sqs/config"
+	"go-sqs/store"
+	"time"
+)
+
+// PostgresLimiter keeps buckets in the rate_limits table, so api instances
+// share them. Each Allow locks the row of its key for one short transaction.
+type PostgresLimiter struct {
+	rateLimitStore *store.RateLimitStore
+}
+
+func NewPostgresLimiter(rateLimitStore *store.RateLimitStore) *PostgresLimiter {
+	return &PostgresLimiter{
+		rateLimitStore: rateLimitStore,
+	}
+}
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, limit config.RateLimit) (Result, error) {
+	var result Result
+	err := l.rateLimitStore.UpdateBucket(ctx, key, float64(limit.Requests), func(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+		tokens, result = take(tokens, elapsed, limit)
+		return tokens, result.Reset
+	})
+	return result, err
+}
+
+func (l *PostgresLimiter) Prune(ctx context.Context) (int, error) {
+	deleted, err := l.rateLimitStore.DeleteFull(ctx)
+	return int(deleted), err
+}
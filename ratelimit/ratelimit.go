@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go-sqs/config"
+	"go-sqs/store"
+	"log/slog"
+	"math"
+	"time"
+)
+
+type Backend string
+
+const (
+	Backend_Memory   Backend = "memory"
+	Backend_Postgres Backend = "postgres"
+)
+
+// Result describes the bucket a token was taken from.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token, zero when Allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter keeps one token bucket per key.
+type Limiter interface {
+	// Allow takes a token from the bucket of key if one is left.
+	Allow(ctx context.Context, key string, limit config.RateLimit) (Result, error)
+	// Prune forgets buckets that have refilled completely.
+	Prune(ctx context.Context) (int, error)
+}
+
+// New builds the limiter selected by RATE_LIMIT_BACKEND.
+func New(conf *config.Config, rateLimitStore *store.RateLimitStore) (Limiter, error) {
+	switch Backend(conf.RateLimitBackend) {
+	case Backend_Memory, "":
+		return NewMemoryLimiter(), nil
+	case Backend_Postgres:
+		return NewPostgresLimiter(rateLimitStore), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit backend %q", conf.RateLimitBackend)
+}
+
+// StartPruning prunes limiter every interval until ctx is done.
+func StartPruning(ctx context.Context, limiter Limiter, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := limiter.Prune(ctx); err != nil {
+			logger.Error("failed to prune rate limit buckets", "error", err)
+		}
+	}
+}
+
+// take refills a bucket that held tokens elapsed ago and takes a token from
+// it. It returns the tokens left and how long until the bucket is full.
+func take(tokens float64, elapsed time.Duration, limit config.RateLimit) (float64, Result) {
+	capacity := float64(limit.Requests)
+	perToken := limit.Per / time.Duration(limit.Requests)
+	tokens = math.Min(capacity, tokens+elapsed.Seconds()/perToken.Seconds())
+
+	result := Result{Limit: limit.Requests}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((capacity - tokens) * float64(perToken))
+	return tokens, result
+}
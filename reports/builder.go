@@ -5,74 +5,100 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"go-sqs/config"
+	"go-sqs/logging"
+	"go-sqs/objectstore"
 	"go-sqs/store"
+	"go-sqs/tracing"
 	"log/slog"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ReportBuilder struct {
 	config      *config.Config
 	reportStore *store.ReportStore
 	lozClient   *LozClient
-	s3Client    *s3.Client
+	objectStore objectstore.ObjectStore
+	encryptor   *Encryptor
 	logger *slog.Logger
+	workerId    string
 }
 
-func NewReportBuilder(reportStore *store.ReportStore, lozClient *LozClient, s3Client *s3.Client, config *config.Config, logger *slog.Logger) *ReportBuilder {
+func NewReportBuilder(reportStore *store.ReportStore, lozClient *LozClient, objectStore objectstore.ObjectStore, encryptor *Encryptor, config *config.Config, logger *slog.Logger) *ReportBuilder {
 	return &ReportBuilder{
 		reportStore: reportStore,
 		lozClient:   lozClient,
-		s3Client:    s3Client,
+		objectStore: objectStore,
+		encryptor:   encryptor,
 		config:      config,
 		logger: logger,
+		workerId:    workerIdentity(config),
 	}
 }
 
-func (b *ReportBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uuid.UUID) (report *store.Report, err error) {
-	report, err = b.reportStore.ByPrimaryKey(ctx, userId, reportId)
+// workerIdentity names this process in report leases.
+func workerIdentity(conf *config.Config) string {
+	if conf.WorkerId != "" {
+		return conf.WorkerId
+	}
+	host, err := os.Hostname()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get report by primary key: %w", err)
+		host = "worker"
 	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
 
-	if report.StartedAt != nil {
-		return report, nil
+func (b *ReportBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uuid.UUID) (report *store.Report, err error) {
+	logger := logging.FromContext(ctx, b.logger)
+	claimed, err := b.reportStore.Claim(ctx, userId, reportId, b.workerId, b.config.ReportLeaseDuration)
+	if errors.Is(err, store.ErrReportNotClaimable) {
+		// completed already, or another worker holds a live lease on it
+		logger.Info("report is not claimable, skipping build", "report_id", reportId)
+		return b.reportStore.ByPrimaryKey(ctx, userId, reportId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim report: %w", err)
 	}
 
+	leaseCtx, stopLease := b.keepLease(ctx, claimed)
+	defer stopLease()
+
+	startedAt := time.Now()
 	defer func() {
+		outcome := "completed"
 		if err != nil {
-			now := time.Now()
-			errMsg := err.Error()
-			report.FailedAt = &now
-			report.ErrorMessage = &errMsg
-			if _, updateErr := b.reportStore.Update(ctx, report); updateErr != nil {
-				b.logger.Error("failed to update report", "error", err.Error())
-			}
+			outcome = "failed"
 		}
+		buildDuration.WithLabelValues(reportTypeLabel(claimed.ReportType), outcome).Observe(time.Since(startedAt).Seconds())
 	}()
 
-	now := time.Now()
-	report.StartedAt = &now
-	report.CompletedAt = nil
-	report.FailedAt = nil
-	report.ErrorMessage = nil
-	report.DownloadUrl = nil
-	report.ExpiresAt = nil
-	report.OutputFilePath = nil
-
-	report, err = b.reportStore.Update(ctx, report)
-	if err != nil {
-		return nil, fmt.Errorf("failed to mark report as started: %w", err)
-	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if errors.Is(context.Cause(leaseCtx), store.ErrLeaseLost) || errors.Is(err, store.ErrLeaseLost) {
+			logger.Warn("lost lease on report, leaving it to its new worker", "report_id", reportId)
+			return
+		}
+		// the build context may be cancelled by now, recording the failure must not be
+		if _, failErr := b.reportStore.Fail(context.WithoutCancel(ctx), userId, reportId, b.workerId, err.Error()); failErr != nil {
+			logger.Error("failed to update report", "error", failErr.Error())
+		}
+	}()
+	ctx = leaseCtx
+	report = claimed
 
-	resp, err := b.lozClient.GetMonsters()
+	resp, err := b.lozClient.GetMonsters(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get monsters from loz client: %w", err)
 	}
@@ -81,6 +107,31 @@ func (b *ReportBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uu
 		return nil, fmt.Errorf("no monsters data returned from loz client")
 	}
 
+	data, err := b.encode(ctx, resp.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	key := "/users/" + userId.String() + "/report/" + reportId.String() + ".csv.gz"
+	if err := b.upload(ctx, key, report, data); err != nil {
+		return nil, err
+	}
+
+	report, err = b.reportStore.Complete(ctx, userId, reportId, b.workerId, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update report %s for user %s: %w", reportId, userId, err)
+	}
+
+	logger.Info("generated report", "report_id", report.Id)
+
+	return report, nil
+}
+
+// encode writes monsters as a gzipped csv.
+func (b *ReportBuilder) encode(ctx context.Context, monsters []Monster) (_ []byte, err error) {
+	_, span := tracer.Start(ctx, "ReportBuilder.encode", trace.WithAttributes(attribute.Int("report.rows", len(monsters))))
+	defer tracing.EndSpan(span, &err)
+
 	var buffer bytes.Buffer
 	gzipWriter := gzip.NewWriter(&buffer)
 	csvWriter := csv.NewWriter(gzipWriter)
@@ -89,7 +140,7 @@ func (b *ReportBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uu
 		return nil, fmt.Errorf("failed to write csv header: %w", err)
 	}
 
-	for _, monster := range resp.Data {
+	for _, monster := range monsters {
 		csvRow := []string{
 			monster.Name,
 			fmt.Sprintf("%d", monster.Id),
@@ -119,25 +170,126 @@ func (b *ReportBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uu
 		return nil, fmt.Errorf("failed to close gzip: %w", err)
 	}
 
-	key := "/users/" + userId.String() + "/report/" + reportId.String() + ".csv.gz"
-	_, err = b.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(b.config.S3Bucket),
-		Body:   bytes.NewReader(buffer.Bytes()),
-	})
+	return buffer.Bytes(), nil
+}
+
+// upload encrypts data as configured and stores it under key.
+func (b *ReportBuilder) upload(ctx context.Context, key string, report *store.Report, data []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "ReportBuilder.upload", trace.WithAttributes(attribute.String("object.key", key)))
+	defer tracing.EndSpan(span, &err)
+
+	putOptions := &objectstore.PutOptions{
+		ContentType:        "text/csv; charset=utf-8",
+		ContentEncoding:    "gzip",
+		ContentDisposition: fmt.Sprintf("attachment; filename=%q", reportFileName(report)),
+		Metadata: map[string]string{
+			"user-id":     report.UserID.String(),
+			"report-id":   report.Id.String(),
+			"report-type": cleanReportType(report.ReportType),
+		},
+		Tags: reportTags(report),
+	}
+
+	body, err := b.encryptor.PrepareUpload(report.UserID, putOptions, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload report to %s: %w", key, err)
+		return fmt.Errorf("failed to encrypt report: %w", err)
 	}
 
-	now = time.Now()
-	report.OutputFilePath = &key
-	report.CompletedAt = &now
-	report, err = b.reportStore.Update(ctx, report)
+	err = b.objectStore.Put(ctx, key, bytes.NewReader(body), *putOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update report %s for user %s: %w", reportId, userId, err)
+		return fmt.Errorf("failed to upload report to %s: %w", key, err)
+	}
+	uploadBytes.WithLabelValues(reportTypeLabel(report.ReportType)).Add(float64(len(body)))
+	span.SetAttributes(attribute.Int("report.upload_bytes", len(body)))
+	return nil
+}
+
+// reportFileName is the name a browser saves the report under. The object is
+// stored with Content-Encoding: gzip, so clients decompress it on download and
+// the name carries the plain .csv extension.
+func reportFileName(report *store.Report) string {
+	return fmt.Sprintf("%s-%s.csv", cleanReportType(report.ReportType), report.CreatedAt.UTC().Format(time.DateOnly))
+}
+
+// ReportTypes are the report types users can request.
+var ReportTypes = []string{"monsters"}
+
+// IsReportType reports whether reportType is one of ReportTypes.
+func IsReportType(reportType string) bool {
+	return slices.Contains(ReportTypes, reportType)
+}
+
+// reportTypeLabel bounds the metric series reports from before report types
+// were validated can create.
+func reportTypeLabel(reportType string) string {
+	if IsReportType(reportType) {
+		return reportType
 	}
+	return "other"
+}
 
-	b.logger.Info("generated report", "report_id", report.Id)
+// maxReportTypeLength keeps the report type well inside the 256 characters S3
+// allows in a tag value.
+const maxReportTypeLength = 64
 
-	return report, nil
+// cleanReportType makes a user supplied report type safe for file names,
+// object tags and metadata, which only take a small set of ASCII characters.
+func cleanReportType(reportType string) string {
+	reportType = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, reportType)
+	if len(reportType) > maxReportTypeLength {
+		reportType = reportType[:maxReportTypeLength]
+	}
+	return reportType
+}
+
+// reportTags are the object tags used by bucket lifecycle rules and cost
+// allocation.
+func reportTags(report *store.Report) map[string]string {
+	return map[string]string{
+		"user-id":     report.UserID.String(),
+		"report-id":   report.Id.String(),
+		"report-type": cleanReportType(report.ReportType),
+	}
+}
+
+// keepLease renews the lease on report until the returned stop function is
+// called. The returned context is cancelled with store.ErrLeaseLost when the
+// lease expired and another worker claimed the report.
+func (b *ReportBuilder) keepLease(ctx context.Context, report *store.Report) (context.Context, func()) {
+	logger := logging.FromContext(ctx, b.logger)
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(b.config.ReportLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := b.reportStore.RenewLease(leaseCtx, report.UserID, report.Id, b.workerId, b.config.ReportLeaseDuration)
+			if errors.Is(err, store.ErrLeaseLost) {
+				cancel(err)
+				return
+			}
+			if err != nil {
+				logger.Error("failed to renew report lease", "error", err.Error(), "report_id", report.Id)
+			}
+		}
+	}()
+
+	return leaseCtx, func() {
+		close(done)
+		cancel(nil)
+	}
 }
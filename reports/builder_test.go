@@ -0,0 +1,25 @@
+package reports_test
+
+import (
+	"go-sqs/reports"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanReportType(t *testing.T) {
+	require.Equal(t, "monsters", reports.CleanReportType("monsters"))
+	require.Equal(t, "boss_fights__", reports.CleanReportType("boss fights/é"))
+
+	// s3 rejects long tag values, which would fail every upload
+	require.Len(t, reports.CleanReportType(strings.Repeat("a", 300)), 64)
+}
+
+func TestIsReportType(t *testing.T) {
+	require.True(t, reports.IsReportType("monsters"))
+
+	// report types label metrics, so unknown ones must not get through
+	require.False(t, reports.IsReportType("Monsters"))
+	require.False(t, reports.IsReportType(""))
+}
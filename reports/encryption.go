@@ -0,0 +1,205 @@
+package reports
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-sqs/config"
+	"go-sqs/objectstore"
+
+	"github.com/google/uuid"
+)
+
+type EncryptionMode string
+
+const (
+	EncryptionNone   EncryptionMode = "none"
+	EncryptionSseS3  EncryptionMode = "sse-s3"
+	EncryptionSseKms EncryptionMode = "sse-kms"
+	EncryptionClient EncryptionMode = "client"
+)
+
+const (
+	envelopeAlgorithm   = "AES256-GCM-HKDF"
+	metaEncryption      = "encryption"
+	metaWrappedKey      = "wrapped-key"
+	masterKeySize       = 32
+	dataKeySize         = 32
+	userKeyInfoTemplate = "go-sqs report key v1 %s"
+)
+
+// Encryptor applies client side encryption to report uploads and reverses it
+// on download. SSE modes are applied by the s3 object store; client mode seals
+// every object with a random data key, which is in turn wrapped with a key
+// derived from the master key and the owning user id.
+type Encryptor struct {
+	mode      EncryptionMode
+	masterKey []byte
+}
+
+func NewEncryptor(conf *config.Config) (*Encryptor, error) {
+	e := &Encryptor{
+		mode: EncryptionMode(conf.ReportEncryption),
+	}
+	if e.mode == "" {
+		e.mode = EncryptionNone
+	}
+
+	if conf.ReportEncryptionKey != "" {
+		masterKey, err := base64.StdEncoding.DecodeString(conf.ReportEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode report encryption key: %w", err)
+		}
+		if len(masterKey) != masterKeySize {
+			return nil, fmt.Errorf("report encryption key must be %d bytes, got %d", masterKeySize, len(masterKey))
+		}
+		e.masterKey = masterKey
+	}
+
+	switch e.mode {
+	case EncryptionNone, EncryptionSseS3:
+	case EncryptionSseKms:
+		if conf.ReportKmsKeyId == "" {
+			return nil, errors.New("REPORT_KMS_KEY_ID is required for sse-kms encryption")
+		}
+	case EncryptionClient:
+		if e.masterKey == nil {
+			return nil, errors.New("REPORT_ENCRYPTION_KEY is required for client encryption")
+		}
+	default:
+		return nil, fmt.Errorf("unknown report encryption mode %q", e.mode)
+	}
+
+	return e, nil
+}
+
+func (e *Encryptor) Mode() EncryptionMode {
+	return e.mode
+}
+
+// PrepareUpload seals body and records the wrapped data key in the object
+// metadata when client encryption is enabled. The returned bytes are what
+// should be uploaded.
+func (e *Encryptor) PrepareUpload(userId uuid.UUID, opts *objectstore.PutOptions, body []byte) ([]byte, error) {
+	if e.mode != EncryptionClient {
+		return body, nil
+	}
+
+	ciphertext, wrappedKey, err := e.seal(userId, body)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Metadata == nil {
+		opts.Metadata = map[string]string{}
+	}
+	opts.Metadata[metaEncryption] = envelopeAlgorithm
+	opts.Metadata[metaWrappedKey] = wrappedKey
+	return ciphertext, nil
+}
+
+// DecryptDownload returns the plaintext of a downloaded report. Objects
+// without envelope metadata are returned untouched, so reports written before
+// client encryption was enabled stay readable.
+func (e *Encryptor) DecryptDownload(userId uuid.UUID, metadata map[string]string, body []byte) ([]byte, error) {
+	algorithm, ok := metadata[metaEncryption]
+	if !ok {
+		return body, nil
+	}
+	if algorithm != envelopeAlgorithm {
+		return nil, fmt.Errorf("unsupported report encryption %q", algorithm)
+	}
+	return e.open(userId, metadata[metaWrappedKey], body)
+}
+
+func (e *Encryptor) seal(userId uuid.UUID, plaintext []byte) ([]byte, string, error) {
+	userKey, err := e.userKey(userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	ciphertext, err := sealGcm(dataKey, plaintext, userId[:])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt report: %w", err)
+	}
+
+	wrappedKey, err := sealGcm(userKey, dataKey, userId[:])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return ciphertext, base64.StdEncoding.EncodeToString(wrappedKey), nil
+}
+
+func (e *Encryptor) open(userId uuid.UUID, wrappedKeyBase64 string, ciphertext []byte) ([]byte, error) {
+	userKey, err := e.userKey(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(wrappedKeyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped data key: %w", err)
+	}
+
+	dataKey, err := openGcm(userKey, wrappedKey, userId[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	plaintext, err := openGcm(dataKey, ciphertext, userId[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt report: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (e *Encryptor) userKey(userId uuid.UUID) ([]byte, error) {
+	if e.masterKey == nil {
+		return nil, errors.New("report encryption key is not configured")
+	}
+	return hkdf.Key(sha256.New, e.masterKey, nil, fmt.Sprintf(userKeyInfoTemplate, userId), dataKeySize)
+}
+
+func sealGcm(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openGcm(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
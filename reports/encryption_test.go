@@ -0,0 +1,60 @@
+package reports_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"go-sqs/config"
+	"go-sqs/objectstore"
+	"go-sqs/reports"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptor(t *testing.T) {
+	masterKey := make([]byte, 32)
+	_, err := rand.Read(masterKey)
+	require.NoError(t, err)
+
+	encryptor, err := reports.NewEncryptor(&config.Config{
+		ReportEncryption:    string(reports.EncryptionClient),
+		ReportEncryptionKey: base64.StdEncoding.EncodeToString(masterKey),
+	})
+	require.NoError(t, err)
+
+	userId := uuid.New()
+	plaintext := []byte("name,id\nbokoblin,1\n")
+	opts := &objectstore.PutOptions{}
+	ciphertext, err := encryptor.PrepareUpload(userId, opts, plaintext)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(ciphertext, plaintext))
+	require.NotEmpty(t, opts.Metadata)
+
+	decrypted, err := encryptor.DecryptDownload(userId, opts.Metadata, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	_, err = encryptor.DecryptDownload(uuid.New(), opts.Metadata, ciphertext)
+	require.Error(t, err)
+
+	unencrypted, err := encryptor.DecryptDownload(userId, map[string]string{}, plaintext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, unencrypted)
+
+	sseKms, err := reports.NewEncryptor(&config.Config{
+		ReportEncryption: string(reports.EncryptionSseKms),
+		ReportKmsKeyId:   "alias/reports",
+	})
+	require.NoError(t, err)
+
+	opts = &objectstore.PutOptions{}
+	body, err := sseKms.PrepareUpload(userId, opts, plaintext)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, body)
+	require.Empty(t, opts.Metadata)
+
+	_, err = reports.NewEncryptor(&config.Config{ReportEncryption: string(reports.EncryptionClient)})
+	require.Error(t, err)
+}
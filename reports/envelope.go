@@ -0,0 +1,219 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-sqs/logging"
+	"go-sqs/store"
+	"go-sqs/tracing"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageType names what a queue message asks the worker to do.
+type MessageType string
+
+const (
+	MessageTypeBuildReport MessageType = "report.build"
+)
+
+// MessageVersion is the payload schema version this build publishes. The
+// worker also accepts older versions listed in payloadDecoders, so producers
+// and workers can be deployed independently.
+const MessageVersion = 1
+
+// Envelope metadata travels in message attributes, the body is the payload.
+const (
+	AttributeSchemaVersion = "schema-version"
+	AttributeMessageType   = "message-type"
+	AttributeCreatedAt     = "created-at"
+	AttributeAttempt       = "attempt"
+	AttributeTraceId       = "trace-id"
+	AttributeCorrelationId = "correlation-id"
+	AttributeRequestId     = "request-id"
+)
+
+var (
+	ErrUnknownMessageType = errors.New("unknown message type")
+	// ErrUnsupportedVersion is returned for payloads newer than this build
+	// understands. A worker from a later deploy may still handle them.
+	ErrUnsupportedVersion = errors.New("unsupported message version")
+	ErrRetiredVersion     = errors.New("retired message version")
+)
+
+type Envelope struct {
+	Version       int
+	Type          MessageType
+	CreatedAt     time.Time
+	Attempt       int
+	TraceId       string
+	CorrelationId string
+	// RequestId is the id of the api request that published the message
+	RequestId string
+	Payload   []byte
+}
+
+// NewEnvelope wraps payload as the current version of msgType. The trace id is
+// generated when traceId is empty.
+func NewEnvelope(msgType MessageType, payload any, traceId string, correlationId string) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", msgType, err)
+	}
+	if traceId == "" {
+		traceId = uuid.NewString()
+	}
+
+	return &Envelope{
+		Version:       MessageVersion,
+		Type:          msgType,
+		CreatedAt:     time.Now().UTC(),
+		Attempt:       1,
+		TraceId:       traceId,
+		CorrelationId: correlationId,
+		Payload:       body,
+	}, nil
+}
+
+func (e *Envelope) Attributes() map[string]string {
+	attributes := map[string]string{
+		AttributeSchemaVersion: strconv.Itoa(e.Version),
+		AttributeMessageType:   string(e.Type),
+		AttributeCreatedAt:     e.CreatedAt.Format(time.RFC3339Nano),
+		AttributeAttempt:       strconv.Itoa(e.Attempt),
+	}
+	if e.TraceId != "" {
+		attributes[AttributeTraceId] = e.TraceId
+	}
+	if e.CorrelationId != "" {
+		attributes[AttributeCorrelationId] = e.CorrelationId
+	}
+	if e.RequestId != "" {
+		attributes[AttributeRequestId] = e.RequestId
+	}
+	return attributes
+}
+
+// OpenEnvelope reads the envelope of a received message. Messages published
+// before envelopes existed carry no version and are read as version 1 report
+// builds.
+func OpenEnvelope(body []byte, attributes map[string]string) (*Envelope, error) {
+	envelope := &Envelope{
+		Version:       1,
+		Type:          MessageTypeBuildReport,
+		Attempt:       1,
+		TraceId:       attributes[AttributeTraceId],
+		CorrelationId: attributes[AttributeCorrelationId],
+		RequestId:     attributes[AttributeRequestId],
+		Payload:       body,
+	}
+
+	if v, ok := attributes[AttributeSchemaVersion]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s attribute %q: %w", AttributeSchemaVersion, v, err)
+		}
+		envelope.Version = version
+	}
+	if t, ok := attributes[AttributeMessageType]; ok {
+		envelope.Type = MessageType(t)
+	}
+	if v, ok := attributes[AttributeCreatedAt]; ok {
+		createdAt, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s attribute %q: %w", AttributeCreatedAt, v, err)
+		}
+		envelope.CreatedAt = createdAt
+	}
+	if v, ok := attributes[AttributeAttempt]; ok {
+		attempt, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s attribute %q: %w", AttributeAttempt, v, err)
+		}
+		envelope.Attempt = attempt
+	}
+
+	return envelope, nil
+}
+
+// NextAttempt returns attributes for publishing the message again, with the
+// attempt counter increased.
+func NextAttempt(attributes map[string]string) map[string]string {
+	next := maps.Clone(attributes)
+	if next == nil {
+		next = map[string]string{}
+	}
+	attempt, err := strconv.Atoi(next[AttributeAttempt])
+	if err != nil || attempt < 1 {
+		attempt = 1
+	}
+	next[AttributeAttempt] = strconv.Itoa(attempt + 1)
+	return next
+}
+
+// payloadDecoders turns each supported version of a report build payload into
+// the current SqsMessage.
+var payloadDecoders = map[int]func(payload []byte) (SqsMessage, error){
+	1: func(payload []byte) (SqsMessage, error) {
+		var msg SqsMessage
+		err := json.Unmarshal(payload, &msg)
+		return msg, err
+	},
+}
+
+// DecodeBuildReport returns the report build request carried by envelope.
+func DecodeBuildReport(envelope *Envelope) (SqsMessage, error) {
+	if envelope.Type != MessageTypeBuildReport {
+		return SqsMessage{}, fmt.Errorf("%w: %q", ErrUnknownMessageType, envelope.Type)
+	}
+	decode, ok := payloadDecoders[envelope.Version]
+	if !ok && envelope.Version > MessageVersion {
+		return SqsMessage{}, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, envelope.Type, envelope.Version)
+	}
+	if !ok {
+		return SqsMessage{}, fmt.Errorf("%w: %s version %d", ErrRetiredVersion, envelope.Type, envelope.Version)
+	}
+	msg, err := decode(envelope.Payload)
+	if err != nil {
+		return SqsMessage{}, fmt.Errorf("failed to unmarshal %s version %d payload: %w", envelope.Type, envelope.Version, err)
+	}
+	return msg, nil
+}
+
+// NewBuildReportMessage builds the outbox message asking a worker to build
+// report, carrying the trace context and request id of ctx. On a FIFO queue
+// each user's reports are built in order, and messages repeating
+// deduplicationId within five minutes are delivered once.
+func NewBuildReportMessage(ctx context.Context, queueName string, report *store.Report, deduplicationId string) (*store.OutboxMessage, error) {
+	// the trace id logged by the worker matches the trace of the request
+	var traceId string
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		traceId = spanContext.TraceID().String()
+	}
+	envelope, err := NewEnvelope(MessageTypeBuildReport, SqsMessage{
+		UserId:   report.UserID,
+		ReportId: report.Id,
+	}, traceId, report.Id.String())
+	if err != nil {
+		return nil, err
+	}
+	envelope.RequestId = logging.RequestId(ctx)
+
+	attributes := envelope.Attributes()
+	tracing.Inject(ctx, attributes)
+
+	groupId := report.UserID.String()
+	return &store.OutboxMessage{
+		Queue:           queueName,
+		Payload:         envelope.Payload,
+		Attributes:      attributes,
+		GroupId:         &groupId,
+		DeduplicationId: &deduplicationId,
+	}, nil
+}
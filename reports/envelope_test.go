@@ -0,0 +1,89 @@
+package reports_test
+
+import (
+	"context"
+	"go-sqs/logging"
+	"go-sqs/reports"
+	"go-sqs/store"
+	"go-sqs/tracing"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEnvelope(t *testing.T) {
+	msg := reports.SqsMessage{UserId: uuid.New(), ReportId: uuid.New()}
+	envelope, err := reports.NewEnvelope(reports.MessageTypeBuildReport, msg, "", msg.ReportId.String())
+	require.NoError(t, err)
+	require.NotEmpty(t, envelope.TraceId)
+
+	opened, err := reports.OpenEnvelope(envelope.Payload, envelope.Attributes())
+	require.NoError(t, err)
+	require.Equal(t, reports.MessageVersion, opened.Version)
+	require.Equal(t, envelope.TraceId, opened.TraceId)
+	require.Equal(t, msg.ReportId.String(), opened.CorrelationId)
+	require.Equal(t, 1, opened.Attempt)
+	require.True(t, envelope.CreatedAt.Equal(opened.CreatedAt))
+
+	decoded, err := reports.DecodeBuildReport(opened)
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+
+	// redriving a message counts as its next attempt
+	next, err := reports.OpenEnvelope(envelope.Payload, reports.NextAttempt(envelope.Attributes()))
+	require.NoError(t, err)
+	require.Equal(t, 2, next.Attempt)
+
+	// messages from before envelopes existed are version 1 report builds
+	legacy, err := reports.OpenEnvelope(envelope.Payload, nil)
+	require.NoError(t, err)
+	decoded, err = reports.DecodeBuildReport(legacy)
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+
+	attributes := envelope.Attributes()
+	attributes[reports.AttributeSchemaVersion] = "99"
+	newer, err := reports.OpenEnvelope(envelope.Payload, attributes)
+	require.NoError(t, err)
+	_, err = reports.DecodeBuildReport(newer)
+	require.ErrorIs(t, err, reports.ErrUnsupportedVersion)
+
+	attributes[reports.AttributeSchemaVersion] = "0"
+	retired, err := reports.OpenEnvelope(envelope.Payload, attributes)
+	require.NoError(t, err)
+	_, err = reports.DecodeBuildReport(retired)
+	require.ErrorIs(t, err, reports.ErrRetiredVersion)
+
+	attributes[reports.AttributeMessageType] = "report.delete"
+	unknown, err := reports.OpenEnvelope(envelope.Payload, attributes)
+	require.NoError(t, err)
+	_, err = reports.DecodeBuildReport(unknown)
+	require.ErrorIs(t, err, reports.ErrUnknownMessageType)
+}
+
+func TestBuildReportMessageCarriesContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(logging.WithRequestId(context.Background(), "request-1"), "POST /reports")
+	defer span.End()
+
+	report := &store.Report{UserID: uuid.New(), Id: uuid.New()}
+	msg, err := reports.NewBuildReportMessage(ctx, "reports", report, report.Id.String())
+	require.NoError(t, err)
+	require.Contains(t, msg.Attributes, "traceparent")
+
+	// the worker logs the trace id of the request and continues its trace
+	envelope, err := reports.OpenEnvelope(msg.Payload, msg.Attributes)
+	require.NoError(t, err)
+	require.Equal(t, span.SpanContext().TraceID().String(), envelope.TraceId)
+	require.Equal(t, "request-1", envelope.RequestId)
+
+	remote := trace.SpanContextFromContext(tracing.Extract(context.Background(), msg.Attributes))
+	require.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), remote.SpanID())
+}
@@ -0,0 +1,53 @@
+package reports
+
+import (
+	"go-sqs/queue"
+
+	"github.com/google/uuid"
+)
+
+// Exported for the tests in reports_test.
+
+var TargetConcurrency = targetConcurrency
+
+var CleanReportType = cleanReportType
+
+// PickOrder buffers buffered[i] messages for a queue of weights[i] and
+// returns the index of the queue each of picks picks took a message from, or
+// -1 once nothing is buffered.
+func PickOrder(weights []int, buffered []int, picks int) []int {
+	picker := &weightedPicker{}
+	index := map[*sourceQueue]int{}
+	for i, weight := range weights {
+		source := &sourceQueue{
+			WeightedQueue: WeightedQueue{Weight: weight},
+			channel:       make(chan queue.Delivery, buffered[i]),
+		}
+		for range buffered[i] {
+			source.channel <- queue.Delivery{}
+		}
+		picker.sources = append(picker.sources, source)
+		index[source] = i
+	}
+
+	order := make([]int, 0, picks)
+	for range picks {
+		source, _, ok := picker.pick()
+		if !ok {
+			order = append(order, -1)
+			continue
+		}
+		order = append(order, index[source])
+	}
+	return order
+}
+
+type UserLimiter = userLimiter
+
+var NewUserLimiter = newUserLimiter
+
+func (l *userLimiter) Acquire(userId uuid.UUID) bool { return l.acquire(userId) }
+
+func (l *userLimiter) Hold(userId uuid.UUID) { l.hold(userId) }
+
+func (l *userLimiter) Release(userId uuid.UUID) { l.release(userId) }
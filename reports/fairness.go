@@ -0,0 +1,101 @@
+package reports
+
+import (
+	"go-sqs/queue"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// WeightedQueue is a queue the worker consumes from. When several queues have
+// messages waiting, each gets a share of the builds proportional to its
+// weight, so a busy scheduled queue cannot starve the interactive one.
+type WeightedQueue struct {
+	Name   string
+	Queue  queue.JobQueue
+	Weight int
+}
+
+type sourceQueue struct {
+	WeightedQueue
+	acker   *queue.Acker
+	channel chan queue.Delivery
+	groups  *groupSequencer
+	current int
+}
+
+// weightedPicker hands out buffered messages across queues with smooth
+// weighted round robin. Queues without buffered messages are skipped, so an
+// idle queue does not hold back the others.
+type weightedPicker struct {
+	mu      sync.Mutex
+	sources []*sourceQueue
+}
+
+// pick takes a buffered message from one of the queues. The caller must know a
+// message is buffered, otherwise pick returns false.
+func (p *weightedPicker) pick() (*sourceQueue, queue.Delivery, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *sourceQueue
+	total := 0
+	for _, source := range p.sources {
+		if len(source.channel) == 0 {
+			continue
+		}
+		source.current += source.Weight
+		total += source.Weight
+		if best == nil || source.current > best.current {
+			best = source
+		}
+	}
+	if best == nil {
+		return nil, queue.Delivery{}, false
+	}
+	best.current -= total
+
+	return best, <-best.channel, true
+}
+
+// userLimiter caps the number of reports built concurrently for one user.
+type userLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inflight map[uuid.UUID]int
+}
+
+func newUserLimiter(limit int) *userLimiter {
+	return &userLimiter{
+		limit:    limit,
+		inflight: map[uuid.UUID]int{},
+	}
+}
+
+// acquire reserves a build slot for the user. A limit of zero disables the cap.
+func (l *userLimiter) acquire(userId uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit > 0 && l.inflight[userId] >= l.limit {
+		return false
+	}
+	l.inflight[userId]++
+	return true
+}
+
+// hold takes a build slot for the user even when the user is at the limit,
+// so builds that cannot be deferred still count against the others.
+func (l *userLimiter) hold(userId uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight[userId]++
+}
+
+func (l *userLimiter) release(userId uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight[userId]--
+	if l.inflight[userId] <= 0 {
+		delete(l.inflight, userId)
+	}
+}
@@ -0,0 +1,58 @@
+package reports_test
+
+import (
+	"go-sqs/reports"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedPicker(t *testing.T) {
+	// smooth weighted round robin interleaves the queues by weight
+	require.Equal(t, []int{0, 1, 0, 0, 1, 0}, reports.PickOrder([]int{2, 1}, []int{10, 10}, 6))
+
+	// shares follow the weights
+	order := reports.PickOrder([]int{3, 1}, []int{100, 100}, 40)
+	counts := map[int]int{}
+	for _, i := range order {
+		counts[i]++
+	}
+	require.Equal(t, map[int]int{0: 30, 1: 10}, counts)
+
+	// an idle queue does not hold back the others
+	require.Equal(t, []int{1, 1, 1}, reports.PickOrder([]int{5, 1}, []int{0, 3}, 3))
+
+	// a drained queue hands its turns to the rest, then nothing is left
+	require.Equal(t, []int{0, 1, 1, 1, -1}, reports.PickOrder([]int{1, 1}, []int{1, 3}, 5))
+}
+
+func TestUserLimiter(t *testing.T) {
+	limiter := reports.NewUserLimiter(2)
+	alice, bob := uuid.New(), uuid.New()
+
+	require.True(t, limiter.Acquire(alice))
+	require.True(t, limiter.Acquire(alice))
+	require.False(t, limiter.Acquire(alice))
+
+	// the cap is per user
+	require.True(t, limiter.Acquire(bob))
+
+	limiter.Release(alice)
+	require.True(t, limiter.Acquire(alice))
+	require.False(t, limiter.Acquire(alice))
+
+	// held slots count against the cap even past it
+	limiter.Hold(bob)
+	limiter.Hold(bob)
+	require.False(t, limiter.Acquire(bob))
+	limiter.Release(bob)
+	limiter.Release(bob)
+	require.True(t, limiter.Acquire(bob))
+
+	// zero disables the cap
+	unlimited := reports.NewUserLimiter(0)
+	for range 10 {
+		require.True(t, unlimited.Acquire(alice))
+	}
+}
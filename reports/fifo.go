@@ -0,0 +1,82 @@
+package reports
+
+import (
+	"go-sqs/queue"
+	"sync"
+)
+
+// groupSequencer keeps deliveries of one FIFO message group from being built
+// concurrently. The first delivery of a group is dispatched, later ones are
+// parked behind it and run by the same pool goroutine once it is done.
+type groupSequencer struct {
+	mu     sync.Mutex
+	groups map[string][]queue.Delivery
+}
+
+func newGroupSequencer() *groupSequencer {
+	return &groupSequencer{
+		groups: map[string][]queue.Delivery{},
+	}
+}
+
+// admit reports whether d can be dispatched right away. Otherwise d is parked
+// behind the delivery of its group that is already running.
+func (s *groupSequencer) admit(d queue.Delivery) bool {
+	if d.GroupId == "" {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parked, busy := s.groups[d.GroupId]; busy {
+		s.groups[d.GroupId] = append(parked, d)
+		return false
+	}
+	s.groups[d.GroupId] = nil
+	return true
+}
+
+// next returns the delivery parked behind d, which the caller runs next. When
+// nothing is parked the group becomes idle again.
+func (s *groupSequencer) next(d queue.Delivery) (queue.Delivery, bool) {
+	if d.GroupId == "" {
+		return queue.Delivery{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parked := s.groups[d.GroupId]
+	if len(parked) == 0 {
+		delete(s.groups, d.GroupId)
+		return queue.Delivery{}, false
+	}
+	s.groups[d.GroupId] = parked[1:]
+	return parked[0], true
+}
+
+// abandon makes d's group idle and returns the deliveries parked behind it.
+// The caller releases them so the queue redelivers the group in order.
+func (s *groupSequencer) abandon(d queue.Delivery) []queue.Delivery {
+	if d.GroupId == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parked := s.groups[d.GroupId]
+	delete(s.groups, d.GroupId)
+	return parked
+}
+
+// drain returns every parked delivery. It is used on shutdown once no
+// delivery is running anymore.
+func (s *groupSequencer) drain() []queue.Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parked []queue.Delivery
+	for group, deliveries := range s.groups {
+		parked = append(parked, deliveries...)
+		delete(s.groups, group)
+	}
+	return parked
+}
@@ -0,0 +1,67 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"go-sqs/store"
+	"log/slog"
+	"time"
+)
+
+const reapBatchSize = 50
+
+// LeaseReaper re-enqueues reports whose worker stopped renewing the lease,
+// usually because it crashed mid build.
+type LeaseReaper struct {
+	reportStore *store.ReportStore
+	queueName   string
+	logger      *slog.Logger
+	interval    time.Duration
+}
+
+func NewLeaseReaper(reportStore *store.ReportStore, queueName string, logger *slog.Logger, interval time.Duration) *LeaseReaper {
+	return &LeaseReaper{
+		reportStore: reportStore,
+		queueName:   queueName,
+		logger:      logger,
+		interval:    interval,
+	}
+}
+
+func (r *LeaseReaper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		requeued, err := r.reap(ctx)
+		if err != nil {
+			r.logger.Error("failed to requeue reports with expired leases", "error", err)
+		}
+
+		// a full batch likely means more reports are waiting
+		if requeued == reapBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *LeaseReaper) reap(ctx context.Context) (int, error) {
+	reports, err := r.reportStore.RequeueExpired(ctx, reapBatchSize, func(report *store.Report) (*store.OutboxMessage, error) {
+		// the original message may still be inside the FIFO deduplication window
+		return NewBuildReportMessage(ctx, r.queueName, report, fmt.Sprintf("%s-%d", report.Id, report.Attempts))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, report := range reports {
+		r.logger.Warn("requeued report with expired lease", "report_id", report.Id, "attempts", report.Attempts)
+	}
+	return len(reports), nil
+}
@@ -1,9 +1,16 @@
 package reports
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"go-sqs/tracing"
 	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const baseUrl = "https://botw-compendium.herokuapp.com/api/v3/compendium"
@@ -39,8 +46,11 @@ type GetMonstersResponse struct {
 	Data []Monster `json:"data"`
 }
 
-func (c *LozClient) GetMonsters() (*GetMonstersResponse, error) {
-	req, err := http.NewRequest("GET", c.baseUrl+"/category/monsters", nil)
+func (c *LozClient) GetMonsters(ctx context.Context) (_ *GetMonstersResponse, err error) {
+	ctx, span := tracer.Start(ctx, "LozClient.GetMonsters", trace.WithSpanKind(trace.SpanKindClient))
+	defer tracing.EndSpan(span, &err)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseUrl+"/category/monsters", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -50,10 +60,14 @@ func (c *LozClient) GetMonsters() (*GetMonstersResponse, error) {
 	queryParams.Set("game", "totk")
 	reqUrl.RawQuery = queryParams.Encode()
 
+	startedAt := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		upstreamLatency.WithLabelValues("error").Observe(time.Since(startedAt).Seconds())
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
+	upstreamLatency.WithLabelValues(strconv.Itoa(resp.StatusCode)).Observe(time.Since(startedAt).Seconds())
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	var response *GetMonstersResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
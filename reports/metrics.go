@@ -0,0 +1,83 @@
+package reports
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_messages_received_total",
+		Help: "Messages received from the job queues.",
+	}, []string{"queue"})
+
+	messagesAcked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_messages_acked_total",
+		Help: "Messages whose report was built and that were handed to the acker.",
+	}, []string{"queue"})
+
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_messages_failed_total",
+		Help: "Messages whose report build failed and that are left for redelivery.",
+	}, []string{"queue"})
+
+	messagesDeferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_messages_deferred_total",
+		Help: "Messages requeued because their user was at the concurrent build limit.",
+	}, []string{"queue"})
+
+	messagesDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_messages_dead_lettered_total",
+		Help: "Messages moved to the dead letters table.",
+	}, []string{"queue"})
+
+	poolSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "reports_worker_pool_size",
+		Help: "Goroutines in the worker pool building reports.",
+	})
+
+	messagesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "reports_messages_in_flight",
+		Help: "Messages currently being handled by the worker pool.",
+	})
+
+	buildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reports_build_duration_seconds",
+		Help:    "Time taken to build a report.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"report_type", "outcome"})
+
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reports_compendium_request_duration_seconds",
+		Help:    "Latency of requests to the compendium API.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"status"})
+
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reports_upload_bytes_total",
+		Help: "Bytes of report objects uploaded to the object store.",
+	}, []string{"report_type"})
+)
+
+// RegisterMetrics registers the worker metrics with registerer. Each process
+// registers its own, so its metrics endpoint only exports what it records.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{
+		messagesReceived,
+		messagesAcked,
+		messagesFailed,
+		messagesDeferred,
+		messagesDeadLettered,
+		poolSize,
+		messagesInFlight,
+		buildDuration,
+		upstreamLatency,
+		uploadBytes,
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"go-sqs/queue"
+	"go-sqs/store"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	outboxBatchSize  = 50
+	outboxLease      = 30 * time.Second
+	outboxMaxBackoff = 5 * time.Minute
+	// published messages are pruned every outboxPruneInterval, in batches
+	// so a large backlog does not hold locks in one long statement
+	outboxPruneInterval  = time.Minute
+	outboxPruneBatchSize = 1000
+)
+
+// OutboxRelay publishes messages written to the outbox table to their job
+// queue. A message is marked published only after the queue accepted it, so
+// delivery is at least once and survives crashes of the relay. Published
+// messages are deleted once they are older than retention.
+type OutboxRelay struct {
+	outboxStore *store.OutboxStore
+	queues      map[string]queue.JobQueue
+	logger      *slog.Logger
+	interval    time.Duration
+	retention   time.Duration
+}
+
+func NewOutboxRelay(outboxStore *store.OutboxStore, queues map[string]queue.JobQueue, logger *slog.Logger, interval time.Duration, retention time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outboxStore: outboxStore,
+		queues:      queues,
+		logger:      logger,
+		interval:    interval,
+		retention:   retention,
+	}
+}
+
+func (r *OutboxRelay) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var prunedAt time.Time
+	for {
+		published, err := r.relay(ctx)
+		if err != nil {
+			r.logger.Error("failed to relay outbox messages", "error", err)
+		}
+
+		if time.Since(prunedAt) >= outboxPruneInterval {
+			r.prune(ctx)
+			prunedAt = time.Now()
+		}
+
+		// a full batch likely means more messages are waiting
+		if published == outboxBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	messages, err := r.outboxStore.ClaimPending(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		if err := r.publish(ctx, msg); err != nil {
+			retryAfter := outboxBackoff(msg.Attempts)
+			r.logger.Error("failed to publish outbox message",
+				"error", err,
+				"outbox_id", msg.Id,
+				"attempts", msg.Attempts,
+				"retry_after", retryAfter.String())
+			if err := r.outboxStore.MarkFailed(ctx, msg.Id, err.Error(), retryAfter); err != nil {
+				r.logger.Error("failed to record outbox failure", "error", err, "outbox_id", msg.Id)
+			}
+			continue
+		}
+
+		if err := r.outboxStore.MarkPublished(ctx, msg.Id); err != nil {
+			// the lease runs out and the message is published again, which
+			// consumers already tolerate
+			r.logger.Error("failed to mark outbox message as published", "error", err, "outbox_id", msg.Id)
+		}
+	}
+
+	return len(messages), nil
+}
+
+// prune deletes published messages past retention, batch by batch until
+// none are left.
+func (r *OutboxRelay) prune(ctx context.Context) {
+	var total int64
+	for ctx.Err() == nil {
+		deleted, err := r.outboxStore.DeletePublished(ctx, r.retention, outboxPruneBatchSize)
+		if err != nil {
+			r.logger.Error("failed to prune outbox", "error", err)
+			break
+		}
+		total += deleted
+		if deleted < outboxPruneBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		r.logger.Info("pruned published outbox messages", "deleted", total)
+	}
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, msg store.OutboxMessage) error {
+	jobQueue, ok := r.queues[msg.Queue]
+	if !ok {
+		return fmt.Errorf("no job queue configured for %q", msg.Queue)
+	}
+	return jobQueue.Publish(ctx, queue.Message{
+		Body:            msg.Payload,
+		Attributes:      msg.Attributes,
+		GroupId:         aws.ToString(msg.GroupId),
+		DeduplicationId: aws.ToString(msg.DeduplicationId),
+	})
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return outboxMaxBackoff
+	}
+	return min(time.Second<<attempts, outboxMaxBackoff)
+}
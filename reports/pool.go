@@ -0,0 +1,157 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"go-sqs/queue"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// latencyWeight is how much a new build duration moves the moving average.
+const latencyWeight = 0.2
+
+type latencyTracker struct {
+	mu      sync.Mutex
+	average time.Duration
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.average == 0 {
+		t.average = d
+		return
+	}
+	t.average = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(t.average))
+}
+
+func (t *latencyTracker) value() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.average
+}
+
+// PoolSize returns the number of goroutines currently building reports.
+func (w *Worker) PoolSize() int {
+	w.poolMu.Lock()
+	defer w.poolMu.Unlock()
+	return len(w.poolQuit)
+}
+
+// resize starts or stops pool goroutines until size are running. Stopped
+// goroutines finish the message they are building before they exit. Once ctx
+// is cancelled the pool is draining and no goroutines are added.
+func (w *Worker) resize(ctx context.Context, processCtx context.Context, size int) {
+	w.poolMu.Lock()
+	defer w.poolMu.Unlock()
+	defer func() { poolSize.Set(float64(len(w.poolQuit))) }()
+
+	for len(w.poolQuit) < size && ctx.Err() == nil {
+		quit := make(chan struct{})
+		w.poolQuit = append(w.poolQuit, quit)
+		w.nextWorkerId++
+		w.poolWg.Add(1)
+		go w.runWorker(ctx, processCtx, w.nextWorkerId, quit)
+	}
+	for len(w.poolQuit) > size {
+		last := len(w.poolQuit) - 1
+		close(w.poolQuit[last])
+		w.poolQuit = w.poolQuit[:last]
+	}
+}
+
+func (w *Worker) runWorker(ctx context.Context, processCtx context.Context, id int, quit chan struct{}) {
+	defer w.poolWg.Done()
+	for {
+		if ctx.Err() != nil {
+			w.logger.Info("Worker shutting down", slog.Int("workerId", id))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-quit:
+			w.logger.Info("Worker removed from pool", slog.Int("workerId", id))
+			return
+		case <-w.pending:
+			if source, message, ok := w.picker.pick(); ok {
+				w.handleGroup(ctx, processCtx, id, source, message)
+			}
+		}
+	}
+}
+
+// autoscale periodically sizes the pool from the queue depth and the recent
+// build latency until ctx is cancelled.
+func (w *Worker) autoscale(ctx context.Context, processCtx context.Context) {
+	ticker := time.NewTicker(w.config.WorkerScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		depth, err := w.queueDepth(ctx)
+		if err != nil {
+			w.logger.Error("failed to get queue depth", "error", err)
+			continue
+		}
+
+		latency := w.latency.value()
+		current := w.PoolSize()
+		target := targetConcurrency(depth, latency, w.config.WorkerScaleInterval, current, w.config.WorkerMinConcurrency, w.config.WorkerMaxConcurrency)
+		if target == current {
+			continue
+		}
+
+		w.resize(ctx, processCtx, target)
+		w.logger.Info("worker pool resized",
+			slog.Int("from", current),
+			slog.Int("to", target),
+			slog.Int("queueDepth", depth),
+			slog.Duration("buildLatency", latency))
+	}
+}
+
+// queueDepth sums the waiting messages of every queue that reports its depth.
+func (w *Worker) queueDepth(ctx context.Context) (int, error) {
+	depth := 0
+	for _, source := range w.sources {
+		depthReporter, ok := source.Queue.(queue.DepthReporter)
+		if !ok {
+			continue
+		}
+		n, err := depthReporter.Depth(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get depth of %s: %w", source.Name, err)
+		}
+		depth += n
+	}
+	return depth, nil
+}
+
+// targetConcurrency is the number of parallel builds needed to work through
+// depth waiting messages within one scale interval, bounded by lo and hi. The
+// pool grows at once but shrinks one goroutine per interval, so a momentarily
+// empty queue does not tear it down.
+func targetConcurrency(depth int, latency time.Duration, interval time.Duration, current int, lo int, hi int) int {
+	target := lo
+	if depth > 0 {
+		target = depth
+		if latency > 0 {
+			target = int(math.Ceil(float64(depth) * latency.Seconds() / interval.Seconds()))
+		}
+	}
+	target = min(max(target, lo), hi)
+
+	if target < current {
+		target = max(current-1, lo)
+	}
+	return target
+}
@@ -0,0 +1,35 @@
+package reports_test
+
+import (
+	"go-sqs/reports"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetConcurrency(t *testing.T) {
+	const interval = 10 * time.Second
+
+	for _, tc := range []struct {
+		name    string
+		depth   int
+		latency time.Duration
+		current int
+		want    int
+	}{
+		{name: "idle pool stays at the minimum", depth: 0, current: 1, want: 1},
+		{name: "no latency yet scales to the depth", depth: 3, current: 1, want: 3},
+		{name: "scales up at once to clear the depth within an interval", depth: 20, latency: 2 * time.Second, current: 1, want: 4},
+		{name: "rounds partial builds up", depth: 7, latency: 3 * time.Second, current: 1, want: 3},
+		{name: "never above the maximum", depth: 100, latency: 5 * time.Second, current: 2, want: 8},
+		{name: "scales down one at a time", depth: 0, current: 6, want: 5},
+		{name: "scales down towards a lower target", depth: 5, latency: 2 * time.Second, current: 4, want: 3},
+		{name: "never below the minimum", depth: 0, current: 1, want: 1},
+		{name: "keeps a pool that fits", depth: 10, latency: 3 * time.Second, current: 3, want: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, reports.TargetConcurrency(tc.depth, tc.latency, interval, tc.current, 1, 8))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package reports
+
+import (
+	"context"
+	"go-sqs/objectstore"
+	"go-sqs/store"
+	"log/slog"
+	"time"
+)
+
+const expireBatchSize = 50
+
+// ReportExpirer moves completed reports past retention to expired and deletes
+// their objects. Downloads stop at once, the objects are deleted after.
+type ReportExpirer struct {
+	reportStore *store.ReportStore
+	objectStore objectstore.ObjectStore
+	logger      *slog.Logger
+	retention   time.Duration
+	interval    time.Duration
+}
+
+func NewReportExpirer(reportStore *store.ReportStore, objectStore objectstore.ObjectStore, logger *slog.Logger, retention time.Duration, interval time.Duration) *ReportExpirer {
+	return &ReportExpirer{
+		reportStore: reportStore,
+		objectStore: objectStore,
+		logger:      logger,
+		retention:   retention,
+		interval:    interval,
+	}
+}
+
+func (e *ReportExpirer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := e.expire(ctx)
+		if err != nil {
+			e.logger.Error("failed to expire completed reports", "error", err)
+		}
+
+		// a full batch likely means more reports are waiting
+		if expired == expireBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire marks a batch of reports expired, then deletes the objects of
+// expired reports. Objects whose delete fails keep their path and are tried
+// again on the next tick.
+func (e *ReportExpirer) expire(ctx context.Context) (int, error) {
+	expired, err := e.reportStore.ExpireCompleted(ctx, e.retention, expireBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, report := range expired {
+		e.logger.Info("expired report", "report_id", report.Id)
+	}
+
+	pending, err := e.reportStore.ExpiredWithOutput(ctx, expireBatchSize)
+	if err != nil {
+		return len(expired), err
+	}
+	for _, report := range pending {
+		if err := e.objectStore.Delete(ctx, *report.OutputFilePath); err != nil {
+			e.logger.Error("failed to delete expired report object", "report_id", report.Id, "key", *report.OutputFilePath, "error", err)
+			continue
+		}
+		if err := e.reportStore.ClearOutput(ctx, report.UserID, report.Id); err != nil {
+			e.logger.Error("failed to clear output of expired report", "report_id", report.Id, "error", err)
+		}
+	}
+	return len(expired), nil
+}
@@ -2,116 +2,367 @@ package reports
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"go-sqs/config"
+	"go-sqs/logging"
+	"go-sqs/queue"
+	"go-sqs/store"
+	"go-sqs/tracing"
 	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("go-sqs/reports")
+
+// errPoisonMessage marks messages that can never be processed, no matter how
+// often they are delivered. They are dead lettered on first sight.
+var errPoisonMessage = errors.New("poison message")
+
+// Builder builds the report a message refers to. ReportBuilder is the
+// implementation the worker runs with.
+type Builder interface {
+	Build(ctx context.Context, userId uuid.UUID, reportId uuid.UUID) (*store.Report, error)
+}
+
 type Worker struct {
-	config      *config.Config
-	builder     *ReportBuilder
-	logger      *slog.Logger
-	sqsClient   *sqs.Client
-	channel     chan types.Message
-	concurrency int
+	config          *config.Config
+	builder         Builder
+	logger          *slog.Logger
+	sources         []*sourceQueue
+	picker          *weightedPicker
+	users           *userLimiter
+	deadLetterStore *store.DeadLetterStore
+	// pending holds one token per message buffered in any source channel
+	pending chan struct{}
+	latency *latencyTracker
+
+	poolMu       sync.Mutex
+	poolQuit     []chan struct{}
+	poolWg       sync.WaitGroup
+	nextWorkerId int
 }
 
-func NewWorker(cfg *config.Config, builder *ReportBuilder, logger *slog.Logger, sqsClient *sqs.Client, maxConcurrency int) *Worker {
+// NewWorker creates a report worker that consumes queues by weight and whose
+// pool scales between the configured minimum and maximum concurrency.
+func NewWorker(cfg *config.Config, builder Builder, logger *slog.Logger, queues []WeightedQueue, deadLetterStore *store.DeadLetterStore) *Worker {
+	sources := make([]*sourceQueue, 0, len(queues))
+	for _, q := range queues {
+		sources = append(sources, &sourceQueue{
+			WeightedQueue: q,
+			acker:         queue.NewAcker(q.Queue, cfg.WorkerAckBatchSize, cfg.WorkerAckFlushInterval, logger),
+			channel:       make(chan queue.Delivery, cfg.WorkerMaxConcurrency),
+			groups:        newGroupSequencer(),
+		})
+	}
+
 	return &Worker{
-		config:      cfg,
-		builder:     builder,
-		logger:      logger,
-		sqsClient:   sqsClient,
-		channel:     make(chan types.Message, maxConcurrency),
-		concurrency: maxConcurrency,
+		config:          cfg,
+		builder:         builder,
+		logger:          logger,
+		sources:         sources,
+		picker:          &weightedPicker{sources: sources},
+		users:           newUserLimiter(cfg.WorkerMaxBuildsPerUser),
+		deadLetterStore: deadLetterStore,
+		pending:         make(chan struct{}, len(sources)*cfg.WorkerMaxConcurrency),
+		latency:         &latencyTracker{},
 	}
 }
 
+// Start receives and processes messages until ctx is cancelled, then drains:
+// it stops receiving, gives in-flight builds until the drain timeout to finish,
+// flushes pending acks and makes messages that were received but not started
+// visible again. It returns once every message has been acked or released.
 func (w *Worker) Start(ctx context.Context) error {
-	queueUrlOutput, err := w.sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
-		QueueName: &w.config.SqsQueue,
+	// builds, acks and heartbeats outlive ctx so in-flight messages can settle
+	processCtx, cancelProcessing := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelProcessing()
+
+	for _, source := range w.sources {
+		source.acker.Start(context.WithoutCancel(ctx))
+	}
+
+	w.resize(ctx, processCtx, w.config.WorkerMinConcurrency)
+	autoscaled := make(chan struct{})
+	go func() {
+		defer close(autoscaled)
+		w.autoscale(ctx, processCtx)
+	}()
+
+	var receivers sync.WaitGroup
+	for _, source := range w.sources {
+		receivers.Add(1)
+		go func() {
+			defer receivers.Done()
+			w.receive(ctx, source)
+		}()
+	}
+	receivers.Wait()
+	// the autoscaler must be done growing the pool before it is waited on
+	<-autoscaled
+
+	drainTimer := time.AfterFunc(w.config.WorkerDrainTimeout, func() {
+		w.logger.Warn("drain timeout exceeded, cancelling in-flight builds")
+		cancelProcessing()
 	})
-	if err != nil {
-		return fmt.Errorf("failed to get SQS queue URL: %w", err)
-	}
-	w.logger.Info("SQS queue URL retrieved", slog.String("queueUrl", *queueUrlOutput.QueueUrl))
-
-	for i := 0; i < w.concurrency; i++ {
-		go func(id int) {
-			for {
-				select {
-				case <-ctx.Done():
-					w.logger.Error("Worker shutting down", slog.Int("workerId", id))
-					return
-				case message := <-w.channel:
-					if err := w.processMessage(ctx, message); err != nil {
-						w.logger.Error("failed to process message", "error", err)
-						continue
-					}
-					w.logger.Info("Worker processing message", slog.Int("workerId", id), slog.String("messageId", *message.MessageId))
-					_, err = w.sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-						QueueUrl:      queueUrlOutput.QueueUrl,
-						ReceiptHandle: message.ReceiptHandle,
-					})
-
-					if err != nil {
-						w.logger.Error("failed to delete message from SQS: %w", err)
-					}
-					continue
-				}
-			}
-		}(i)
+	defer drainTimer.Stop()
+	w.poolWg.Wait()
+
+	for _, source := range w.sources {
+		source.acker.Close()
+		w.release(context.WithoutCancel(ctx), source)
 	}
+	w.logger.Info("worker drained")
+	return nil
+}
 
+func (w *Worker) receive(ctx context.Context, source *sourceQueue) {
 	for {
-		output, err := w.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-			QueueUrl:            queueUrlOutput.QueueUrl,
-			MaxNumberOfMessages: int32(w.concurrency),
-			WaitTimeSeconds:     10,
-		})
+		messages, err := source.Queue.Receive(ctx, w.PoolSize())
 		if err != nil {
-			w.logger.Error("failed to receive messages", "error", err)
 			if ctx.Err() != nil {
-				return ctx.Err()
+				return
+			}
+			w.logger.Error("failed to receive messages", "error", err, slog.String("queue", source.Name))
+		}
+		messagesReceived.WithLabelValues(source.Name).Add(float64(len(messages)))
+
+		for i, message := range messages {
+			if !source.groups.admit(message) {
+				continue
 			}
+			select {
+			case source.channel <- message:
+				w.pending <- struct{}{}
+			case <-ctx.Done():
+				w.nack(context.WithoutCancel(ctx), source, messages[i:])
+				return
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// release makes messages that were handed to the workers but never started
+// visible again, so another worker can pick them up right away.
+func (w *Worker) release(ctx context.Context, source *sourceQueue) {
+	buffered := source.groups.drain()
+	for {
+		select {
+		case message := <-source.channel:
+			buffered = append(buffered, message)
+		default:
+			w.nack(ctx, source, buffered)
+			return
 		}
+	}
+}
 
-		if len(output.Messages) == 0 {
+func (w *Worker) nack(ctx context.Context, source *sourceQueue, messages []queue.Delivery) {
+	for _, message := range messages {
+		if err := source.Queue.Nack(ctx, message, 0); err != nil {
+			w.logger.Error("failed to release message", "error", err, slog.String("messageId", message.Id))
 			continue
 		}
+		w.logger.Info("released unprocessed message", slog.String("messageId", message.Id))
+	}
+}
+
+// handleGroup handles message and then the messages of its FIFO group that
+// were received while it was running. Once a message of the group is not
+// settled, the rest are released so the queue redelivers them in order.
+func (w *Worker) handleGroup(ctx context.Context, processCtx context.Context, workerId int, source *sourceQueue, message queue.Delivery) {
+	for {
+		if !w.handleMessage(processCtx, workerId, source, message) || ctx.Err() != nil {
+			w.nack(context.WithoutCancel(ctx), source, source.groups.abandon(message))
+			return
+		}
+
+		next, ok := source.groups.next(message)
+		if !ok {
+			return
+		}
+		message = next
+	}
+}
+
+// handleMessage builds the report for message. It reports whether the message
+// was settled, either acked or dead lettered.
+func (w *Worker) handleMessage(ctx context.Context, workerId int, source *sourceQueue, message queue.Delivery) bool {
+	messagesInFlight.Inc()
+	defer messagesInFlight.Dec()
+
+	if message.ReceiveCount > w.config.WorkerMaxReceives {
+		return w.deadLetter(ctx, source, message, fmt.Sprintf("exceeded %d receives", w.config.WorkerMaxReceives))
+	}
+
+	envelope, msg, err := decodeMessage(message)
+	if errors.Is(err, ErrUnsupportedVersion) && message.ReceiveCount < w.config.WorkerMaxReceives {
+		// a worker from a newer deploy may understand the message
+		w.logger.Warn("releasing message with unsupported version", "error", err, slog.String("messageId", message.Id))
+		if err := source.Queue.Nack(ctx, message, w.config.WorkerUnsupportedVersionDelay); err != nil {
+			w.logger.Error("failed to release message", "error", err, slog.String("messageId", message.Id))
+		}
+		return false
+	}
+	if err != nil {
+		w.logger.Error("failed to process message", "error", err, slog.String("messageId", message.Id))
+		return w.deadLetter(ctx, source, message, err.Error())
+	}
+	logger := w.logger.With(
+		slog.String("messageId", message.Id),
+		slog.String("traceId", envelope.TraceId),
+		slog.String("correlationId", envelope.CorrelationId),
+		slog.String("request_id", envelope.RequestId),
+		slog.Int("attempt", envelope.Attempt))
+	ctx = logging.WithLogger(ctx, logger)
+
+	if message.GroupId != "" {
+		// FIFO groups are per user, so the queue already runs one build per
+		// user at a time and a deferred copy would fall behind later messages
+		// of its group
+		w.users.hold(msg.UserId)
+	} else if !w.users.acquire(msg.UserId) {
+		w.postpone(ctx, source, message, msg)
+		return false
+	}
+	defer w.users.release(msg.UserId)
+
+	stopHeartbeat := queue.StartHeartbeat(ctx, source.Queue, message, w.config.WorkerHeartbeatInterval, w.config.JobVisibilityTimeout, func(err error) {
+		logger.Error("failed to extend message visibility", "error", err)
+	})
+	startedAt := time.Now()
+	err = w.processMessage(ctx, message, msg)
+	stopHeartbeat()
+	w.latency.observe(time.Since(startedAt))
 
-		for _, message := range output.Messages {
-			w.channel <- message
+	if err != nil && ctx.Err() != nil {
+		// the drain timeout cancelled the build, hand the message to another
+		// worker right away rather than after its visibility timeout
+		logger.Warn("releasing message cancelled by shutdown", "error", err)
+		if err := source.Queue.Nack(context.WithoutCancel(ctx), message, 0); err != nil {
+			logger.Error("failed to release message", "error", err)
 		}
+		return false
+	}
+	if err != nil {
+		logger.Error("failed to process message", "error", err, slog.Int("receiveCount", message.ReceiveCount))
+		messagesFailed.WithLabelValues(source.Name).Inc()
+		if message.ReceiveCount >= w.config.WorkerMaxReceives {
+			return w.deadLetter(ctx, source, message, err.Error())
+		}
+		return false
 	}
 
+	logger.Info("Worker processing message", slog.Int("workerId", workerId), slog.String("queue", source.Name))
+	source.acker.Ack(message)
+	messagesAcked.WithLabelValues(source.Name).Inc()
+	return true
 }
 
-func (w *Worker) processMessage(ctx context.Context, message types.Message) error {
-	if message.Body == nil || *message.Body == "" {
-		w.logger.Warn("Received empty message body", slog.String("messageId", *message.MessageId))
-		return nil
+// postpone hands a message of a standard queue back because the user already
+// has as many builds running as the fairness policy allows. It publishes a
+// delayed copy and acks the original rather than nacking it, since every
+// redelivery raises the receive count and deferrals must not use up the
+// attempts that lead to the dead letters. FIFO messages are never postponed,
+// their queues do not delay single messages. If publishing fails the message
+// is left to its visibility timeout.
+func (w *Worker) postpone(ctx context.Context, source *sourceQueue, message queue.Delivery, msg SqsMessage) {
+	logger := w.logger.With(slog.String("messageId", message.Id), slog.String("userId", msg.UserId.String()))
+	if err := source.Queue.Publish(ctx, queue.Message{
+		Body:       message.Body,
+		Attributes: message.Attributes,
+		Delay:      w.config.WorkerFairnessDelay,
+	}); err != nil {
+		logger.Error("failed to defer message", "error", err)
+		return
 	}
+	source.acker.Ack(message)
+	messagesDeferred.WithLabelValues(source.Name).Inc()
+	logger.Info("deferred message, user is at the concurrent build limit")
+}
 
-	var msg SqsMessage
-	if err := json.Unmarshal([]byte(*message.Body), &msg); err != nil {
-		w.logger.Error("failed to unmarshal message body", "error", err, slog.String("messageId", *message.MessageId))
-		return nil
+// decodeMessage opens the envelope of message and decodes its payload. Only
+// ErrUnsupportedVersion is worth retrying, every other error marks a poison
+// message.
+func decodeMessage(message queue.Delivery) (*Envelope, SqsMessage, error) {
+	if len(message.Body) == 0 {
+		return nil, SqsMessage{}, fmt.Errorf("%w: empty message body", errPoisonMessage)
+	}
+	envelope, err := OpenEnvelope(message.Body, message.Attributes)
+	if err != nil {
+		return nil, SqsMessage{}, fmt.Errorf("%w: %w", errPoisonMessage, err)
+	}
+	msg, err := DecodeBuildReport(envelope)
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return envelope, msg, err
+	}
+	if err != nil {
+		return nil, SqsMessage{}, fmt.Errorf("%w: %w", errPoisonMessage, err)
 	}
+	return envelope, msg, nil
+}
+
+// processMessage builds the report in a span continuing the trace the api
+// server injected into the message attributes.
+func (w *Worker) processMessage(ctx context.Context, message queue.Delivery, msg SqsMessage) (err error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, message.Attributes), "Worker.processMessage",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", message.Id),
+			attribute.Int("messaging.receive_count", message.ReceiveCount),
+			attribute.String("report.id", msg.ReportId.String())))
+	defer tracing.EndSpan(span, &err)
 
-	builderCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	builderCtx, cancel := context.WithTimeout(ctx, w.config.WorkerBuildTimeout)
 	defer cancel()
 
-	_, err := w.builder.Build(builderCtx, msg.UserId, msg.ReportId)
+	_, err = w.builder.Build(builderCtx, msg.UserId, msg.ReportId)
 	if err != nil {
 		return fmt.Errorf("failed to build report: %w", err)
 	}
 
-	w.logger.Info("Successfully processed and deleted message", slog.String("messageId", *message.MessageId))
+	logging.FromContext(ctx, w.logger).Info("Successfully processed and deleted message")
 	return nil
 }
+
+// deadLetter records the message in the dead letters table and removes it
+// from the work queue. The table is the only dead letter queue: admins
+// inspect, redrive and discard messages there, so no copy is kept in SQS that
+// could be redriven or counted twice. If recording fails the message is left
+// on the work queue and dead lettered again on its next delivery, and
+// deadLetter returns false.
+func (w *Worker) deadLetter(ctx context.Context, source *sourceQueue, message queue.Delivery, reason string) bool {
+	logger := w.logger.With(slog.String("messageId", message.Id), slog.Int("receiveCount", message.ReceiveCount), slog.String("reason", reason))
+
+	// the attributes are republished on redrive, which is the next attempt
+	deadLetter := &store.DeadLetter{
+		Queue:        source.Name,
+		MessageId:    message.Id,
+		Body:         message.Body,
+		Attributes:   NextAttempt(message.Attributes),
+		ReceiveCount: message.ReceiveCount,
+		Reason:       reason,
+	}
+	if message.GroupId != "" {
+		deadLetter.GroupId = &message.GroupId
+	}
+	if _, err := w.deadLetterStore.Create(ctx, deadLetter); err != nil {
+		logger.Error("failed to record dead letter", "error", err)
+		return false
+	}
+
+	source.acker.Ack(message)
+	messagesDeadLettered.WithLabelValues(source.Name).Inc()
+	logger.Warn("moved message to dead letters")
+	return true
+}
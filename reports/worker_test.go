@@ -0,0 +1,238 @@
+package reports_test
+
+import (
+	"context"
+	"go-sqs/config"
+	"go-sqs/queue"
+	"go-sqs/reports"
+	"go-sqs/store"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBuilder blocks every build until release is closed or the build is
+// cancelled, and records the reports it was asked for.
+type fakeBuilder struct {
+	started chan uuid.UUID
+	release chan struct{}
+
+	mu    sync.Mutex
+	built []uuid.UUID
+}
+
+func newFakeBuilder() *fakeBuilder {
+	return &fakeBuilder{
+		started: make(chan uuid.UUID, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *fakeBuilder) Build(ctx context.Context, userId uuid.UUID, reportId uuid.UUID) (*store.Report, error) {
+	b.started <- reportId
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.built = append(b.built, reportId)
+	return &store.Report{UserID: userId, Id: reportId}, nil
+}
+
+func (b *fakeBuilder) Built() []uuid.UUID {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]uuid.UUID(nil), b.built...)
+}
+
+func testWorkerConfig() *config.Config {
+	return &config.Config{
+		WorkerMinConcurrency:          1,
+		WorkerMaxConcurrency:          1,
+		WorkerMaxReceives:             5,
+		WorkerBuildTimeout:            10 * time.Second,
+		WorkerHeartbeatInterval:       time.Second,
+		JobVisibilityTimeout:          time.Minute,
+		WorkerDrainTimeout:            time.Second,
+		WorkerAckBatchSize:            10,
+		WorkerAckFlushInterval:        10 * time.Millisecond,
+		WorkerScaleInterval:           time.Hour,
+		WorkerFairnessDelay:           time.Millisecond,
+		WorkerUnsupportedVersionDelay: time.Millisecond,
+	}
+}
+
+func publishReports(t *testing.T, jobQueue queue.JobQueue, userId uuid.UUID, n int) []uuid.UUID {
+	return publishGroupedReports(t, jobQueue, userId, n, false)
+}
+
+// publishGroupedReports publishes n reports for userId, in the user's message
+// group when grouped is set, like on a FIFO queue.
+func publishGroupedReports(t *testing.T, jobQueue queue.JobQueue, userId uuid.UUID, n int, grouped bool) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, 0, n)
+	for range n {
+		report := &store.Report{UserID: userId, Id: uuid.New()}
+		msg, err := reports.NewBuildReportMessage(context.Background(), "reports", report, report.Id.String())
+		require.NoError(t, err)
+		published := queue.Message{Body: msg.Payload, Attributes: msg.Attributes}
+		if grouped {
+			published.GroupId = *msg.GroupId
+			published.DeduplicationId = *msg.DeduplicationId
+		}
+		require.NoError(t, jobQueue.Publish(context.Background(), published))
+		ids = append(ids, report.Id)
+	}
+	return ids
+}
+
+// receiveAll collects the deliveries waiting on jobQueue.
+func receiveAll(t *testing.T, jobQueue queue.JobQueue) []queue.Delivery {
+	t.Helper()
+	var deliveries []queue.Delivery
+	for {
+		batch, err := jobQueue.Receive(context.Background(), 10)
+		require.NoError(t, err)
+		if len(batch) == 0 {
+			return deliveries
+		}
+		deliveries = append(deliveries, batch...)
+	}
+}
+
+func startWorker(t *testing.T, worker *reports.Worker) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- worker.Start(ctx)
+	}()
+	return func() {
+		cancel()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("worker did not drain")
+		}
+	}
+}
+
+func TestWorkerDrainFinishesInFlightAndReleasesBuffered(t *testing.T) {
+	jobQueue := queue.NewMemoryQueue(time.Minute, 20*time.Millisecond)
+	ids := publishReports(t, jobQueue, uuid.New(), 3)
+
+	builder := newFakeBuilder()
+	worker := reports.NewWorker(testWorkerConfig(), builder, slog.Default(),
+		[]reports.WeightedQueue{{Name: "reports", Queue: jobQueue, Weight: 1}}, nil)
+	stop := startWorker(t, worker)
+
+	inFlight := <-builder.started
+	require.Equal(t, ids[0], inFlight)
+
+	// the in-flight build finishes during the drain
+	time.AfterFunc(50*time.Millisecond, func() { close(builder.release) })
+	stop()
+	require.Equal(t, []uuid.UUID{inFlight}, builder.Built())
+
+	// the finished message was acked, the others are visible again at once
+	released := receiveAll(t, jobQueue)
+	require.Len(t, released, 2)
+	for _, delivery := range released {
+		envelope, err := reports.OpenEnvelope(delivery.Body, delivery.Attributes)
+		require.NoError(t, err)
+		msg, err := reports.DecodeBuildReport(envelope)
+		require.NoError(t, err)
+		require.NotEqual(t, inFlight, msg.ReportId)
+	}
+}
+
+func TestWorkerDrainTimeoutReleasesInFlight(t *testing.T) {
+	jobQueue := queue.NewMemoryQueue(time.Minute, 20*time.Millisecond)
+	ids := publishReports(t, jobQueue, uuid.New(), 1)
+
+	conf := testWorkerConfig()
+	conf.WorkerDrainTimeout = 50 * time.Millisecond
+	builder := newFakeBuilder()
+	worker := reports.NewWorker(conf, builder, slog.Default(),
+		[]reports.WeightedQueue{{Name: "reports", Queue: jobQueue, Weight: 1}}, nil)
+	stop := startWorker(t, worker)
+
+	require.Equal(t, ids[0], <-builder.started)
+
+	// the build never finishes, so the drain timeout cancels it and the
+	// message goes back to the queue instead of waiting out its visibility
+	stop()
+	require.Empty(t, builder.Built())
+
+	released := receiveAll(t, jobQueue)
+	require.Len(t, released, 1)
+	require.Equal(t, 2, released[0].ReceiveCount)
+}
+
+func TestWorkerDefersUsersAtTheBuildLimit(t *testing.T) {
+	jobQueue := queue.NewMemoryQueue(time.Minute, 20*time.Millisecond)
+	userId := uuid.New()
+	ids := publishReports(t, jobQueue, userId, 2)
+
+	// a single receive is all the dead letter budget allows, deferrals must
+	// not use it up
+	conf := testWorkerConfig()
+	conf.WorkerMinConcurrency = 2
+	conf.WorkerMaxConcurrency = 2
+	conf.WorkerMaxBuildsPerUser = 1
+	conf.WorkerMaxReceives = 1
+	conf.WorkerFairnessDelay = 20 * time.Millisecond
+	builder := newFakeBuilder()
+	worker := reports.NewWorker(conf, builder, slog.Default(),
+		[]reports.WeightedQueue{{Name: "reports", Queue: jobQueue, Weight: 1}}, nil)
+	stop := startWorker(t, worker)
+	defer stop()
+
+	first := <-builder.started
+	// the second report waits while the first builds, however many times it
+	// is deferred
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case reportId := <-builder.started:
+		t.Fatalf("report %s built while its user was at the limit", reportId)
+	default:
+	}
+
+	close(builder.release)
+	require.Eventually(t, func() bool {
+		return len(builder.Built()) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	require.ElementsMatch(t, ids, builder.Built())
+	require.Contains(t, ids, first)
+}
+
+func TestWorkerKeepsFifoGroupsInOrderAtTheBuildLimit(t *testing.T) {
+	jobQueue := queue.NewMemoryQueue(time.Minute, 20*time.Millisecond)
+	ids := publishGroupedReports(t, jobQueue, uuid.New(), 3, true)
+
+	// FIFO messages are not deferred, the group already runs one build of the
+	// user at a time and a deferred copy would fall behind the rest of it
+	conf := testWorkerConfig()
+	conf.WorkerMinConcurrency = 2
+	conf.WorkerMaxConcurrency = 2
+	conf.WorkerMaxBuildsPerUser = 1
+	conf.WorkerMaxReceives = 1
+	builder := newFakeBuilder()
+	close(builder.release)
+	worker := reports.NewWorker(conf, builder, slog.Default(),
+		[]reports.WeightedQueue{{Name: "reports", Queue: jobQueue, Weight: 1}}, nil)
+	stop := startWorker(t, worker)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		return len(builder.Built()) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, ids, builder.Built())
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// DeadLetterStore holds messages the worker gave up on. It is the only dead
+// letter queue, nothing is kept in SQS, so a redrive or discard here is the
+// whole story.
+type DeadLetterStore struct {
+	db *sqlx.DB
+}
+
+func NewDeadLetterStore(db *sql.DB) *DeadLetterStore {
+	return &DeadLetterStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type DeadLetter struct {
+	Id           int64      `db:"id"`
+	Queue        string     `db:"queue"`
+	MessageId    string     `db:"message_id"`
+	Body         []byte     `db:"body"`
+	Attributes   Attributes `db:"attributes"`
+	GroupId      *string    `db:"group_id"`
+	ReceiveCount int        `db:"receive_count"`
+	Reason       string     `db:"reason"`
+	CreatedAt    time.Time  `db:"created_at"`
+	RedrivenAt   *time.Time `db:"redriven_at"`
+	DiscardedAt  *time.Time `db:"discarded_at"`
+}
+
+func (s *DeadLetterStore) Create(ctx context.Context, deadLetter *DeadLetter) (*DeadLetter, error) {
+	const insert = `INSERT INTO dead_letters (queue, message_id, body, attributes, group_id, receive_count, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *;`
+
+	var created DeadLetter
+	if err := s.db.GetContext(ctx, &created, insert,
+		deadLetter.Queue,
+		deadLetter.MessageId,
+		deadLetter.Body,
+		deadLetter.Attributes,
+		deadLetter.GroupId,
+		deadLetter.ReceiveCount,
+		deadLetter.Reason); err != nil {
+		return nil, fmt.Errorf("failed to insert dead letter for message %s: %w", deadLetter.MessageId, err)
+	}
+	return &created, nil
+}
+
+// Pending lists dead letters that were neither redriven nor discarded, oldest
+// first.
+func (s *DeadLetterStore) Pending(ctx context.Context, limit int, offset int) ([]DeadLetter, error) {
+	const query = `SELECT * FROM dead_letters
+		WHERE redriven_at IS NULL AND discarded_at IS NULL
+		ORDER BY created_at, id
+		LIMIT $1 OFFSET $2;`
+
+	deadLetters := []DeadLetter{}
+	if err := s.db.SelectContext(ctx, &deadLetters, query, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	return deadLetters, nil
+}
+
+func (s *DeadLetterStore) ByPrimaryKey(ctx context.Context, id int64) (*DeadLetter, error) {
+	const query = `SELECT * FROM dead_letters WHERE id = $1;`
+	var deadLetter DeadLetter
+	if err := s.db.GetContext(ctx, &deadLetter, query, id); err != nil {
+		return nil, fmt.Errorf("failed to query dead letter %d: %w", id, err)
+	}
+	return &deadLetter, nil
+}
+
+// Redrive marks a pending dead letter as redriven and writes its message back
+// to the outbox in the same transaction, so the relay publishes it to the
+// original queue. The redriven message gets its own deduplication id, so a
+// FIFO queue does not drop it as a repeat of the original. It returns
+// sql.ErrNoRows if the dead letter is not pending.
+func (s *DeadLetterStore) Redrive(ctx context.Context, id int64) (*DeadLetter, error) {
+	const update = `UPDATE dead_letters SET redriven_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND redriven_at IS NULL AND discarded_at IS NULL RETURNING *;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deadLetter DeadLetter
+	if err := tx.GetContext(ctx, &deadLetter, update, id); err != nil {
+		return nil, fmt.Errorf("failed to mark dead letter %d as redriven: %w", id, err)
+	}
+
+	deduplicationId := fmt.Sprintf("dead-letter-%d", deadLetter.Id)
+	if _, err := insertOutboxMessage(ctx, tx, &OutboxMessage{
+		Queue:           deadLetter.Queue,
+		Payload:         deadLetter.Body,
+		Attributes:      deadLetter.Attributes,
+		GroupId:         deadLetter.GroupId,
+		DeduplicationId: &deduplicationId,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit redrive of dead letter %d: %w", id, err)
+	}
+	return &deadLetter, nil
+}
+
+// Discard marks a pending dead letter as discarded. It returns sql.ErrNoRows
+// if the dead letter is not pending.
+func (s *DeadLetterStore) Discard(ctx context.Context, id int64) (*DeadLetter, error) {
+	const update = `UPDATE dead_letters SET discarded_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND redriven_at IS NULL AND discarded_at IS NULL RETURNING *;`
+
+	var deadLetter DeadLetter
+	if err := s.db.GetContext(ctx, &deadLetter, update, id); err != nil {
+		return nil, fmt.Errorf("failed to discard dead letter %d: %w", id, err)
+	}
+	return &deadLetter, nil
+}
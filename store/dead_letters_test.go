@@ -0,0 +1,75 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	deadLetterStore := store.NewDeadLetterStore(env.DB)
+	outboxStore := store.NewOutboxStore(env.DB)
+
+	poison, err := deadLetterStore.Create(ctx, &store.DeadLetter{
+		Queue:        "reports",
+		MessageId:    "message-1",
+		Body:         []byte("not json"),
+		ReceiveCount: 1,
+		Reason:       "invalid body",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "reports", poison.Queue)
+
+	groupId := "user-1"
+	failing, err := deadLetterStore.Create(ctx, &store.DeadLetter{
+		Queue:        "reports",
+		MessageId:    "message-2",
+		Body:         []byte(`{"reportId":"1"}`),
+		Attributes:   store.Attributes{"type": "report"},
+		GroupId:      &groupId,
+		ReceiveCount: 5,
+		Reason:       "max receives exceeded",
+	})
+	require.NoError(t, err)
+
+	pending, err := deadLetterStore.Pending(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	discarded, err := deadLetterStore.Discard(ctx, poison.Id)
+	require.NoError(t, err)
+	require.NotNil(t, discarded.DiscardedAt)
+
+	redriven, err := deadLetterStore.Redrive(ctx, failing.Id)
+	require.NoError(t, err)
+	require.NotNil(t, redriven.RedrivenAt)
+
+	_, err = deadLetterStore.Redrive(ctx, poison.Id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	pending, err = deadLetterStore.Pending(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	messages, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, "reports", messages[0].Queue)
+	require.Equal(t, failing.Body, messages[0].Payload)
+	require.Equal(t, "report", messages[0].Attributes["type"])
+	require.Equal(t, &groupId, messages[0].GroupId)
+	require.Equal(t, fmt.Sprintf("dead-letter-%d", failing.Id), *messages[0].DeduplicationId)
+}
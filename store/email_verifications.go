@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// ErrInvalidVerificationToken is returned for tokens that are unknown,
+// expired or already used.
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+type EmailVerificationStore struct {
+	db *sqlx.DB
+}
+
+func NewEmailVerificationStore(db *sql.DB) *EmailVerificationStore {
+	return &EmailVerificationStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// hashVerificationToken is what gets stored, so a leaked table cannot be used
+// to verify addresses.
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a verification token for the user valid for ttl and returns
+// it. Tokens issued before are revoked, only the latest email works.
+func (s *EmailVerificationStore) Create(ctx context.Context, userId uuid.UUID, ttl time.Duration) (string, error) {
+	const revoke = `DELETE FROM email_verification_tokens WHERE user_id = $1;`
+	const insert = `INSERT INTO email_verification_tokens (hashed_token, user_id, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond');`
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate verification token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, revoke, userId); err != nil {
+		return "", fmt.Errorf("failed to revoke verification tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, insert, hashVerificationToken(token), userId, ttl.Milliseconds()); err != nil {
+		return "", fmt.Errorf("failed to create verification token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit verification token: %w", err)
+	}
+	return token, nil
+}
+
+// Verify consumes token and marks the email of its user as verified. A user
+// verifying again keeps the time of the first verification.
+func (s *EmailVerificationStore) Verify(ctx context.Context, token string) (*User, error) {
+	const consume = `DELETE FROM email_verification_tokens
+		WHERE hashed_token = $1 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id;`
+	const verify = `UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 RETURNING *;`
+	const revoke = `DELETE FROM email_verification_tokens WHERE user_id = $1;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userId uuid.UUID
+	if err := tx.GetContext(ctx, &userId, consume, hashVerificationToken(token)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, fmt.Errorf("failed to consume verification token: %w", err)
+	}
+
+	var user User
+	if err := tx.GetContext(ctx, &user, verify, userId); err != nil {
+		return nil, fmt.Errorf("failed to verify email of user %s: %w", userId, err)
+	}
+	if _, err := tx.ExecContext(ctx, revoke, userId); err != nil {
+		return nil, fmt.Errorf("failed to revoke verification tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit email verification: %w", err)
+	}
+	return &user, nil
+}
+
+// DeleteExpired removes tokens that can no longer be used.
+func (s *EmailVerificationStore) DeleteExpired(ctx context.Context) (int64, error) {
+	const query = `DELETE FROM email_verification_tokens WHERE expires_at <= CURRENT_TIMESTAMP;`
+
+	result, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired verification tokens: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+	return deleted, nil
+}
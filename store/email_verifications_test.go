@@ -0,0 +1,58 @@
+package store_test
+
+import (
+	"context"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailVerificationStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	verificationStore := store.NewEmailVerificationStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+	user, err := userStore.CreateUser(ctx, "test@test.com", "secretpassword")
+	require.NoError(t, err)
+	require.False(t, user.EmailVerified())
+
+	// issuing a new token revokes the previous one
+	first, err := verificationStore.Create(ctx, user.Id, time.Hour)
+	require.NoError(t, err)
+	second, err := verificationStore.Create(ctx, user.Id, time.Hour)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	_, err = verificationStore.Verify(ctx, first)
+	require.ErrorIs(t, err, store.ErrInvalidVerificationToken)
+
+	verified, err := verificationStore.Verify(ctx, second)
+	require.NoError(t, err)
+	require.Equal(t, user.Id, verified.Id)
+	require.True(t, verified.EmailVerified())
+
+	// tokens are single use
+	_, err = verificationStore.Verify(ctx, second)
+	require.ErrorIs(t, err, store.ErrInvalidVerificationToken)
+
+	expired, err := verificationStore.Create(ctx, user.Id, -time.Minute)
+	require.NoError(t, err)
+	_, err = verificationStore.Verify(ctx, expired)
+	require.ErrorIs(t, err, store.ErrInvalidVerificationToken)
+
+	deleted, err := verificationStore.DeleteExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	user, err = userStore.ByID(ctx, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, verified.EmailVerifiedAt.Unix(), user.EmailVerifiedAt.Unix())
+}
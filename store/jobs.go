@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type JobStore struct {
+	db *sqlx.DB
+}
+
+func NewJobStore(db *sql.DB) *JobStore {
+	return &JobStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// Attributes is a string map stored as a JSONB column.
+type Attributes map[string]string
+
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *Attributes) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	}
+	return fmt.Errorf("cannot scan %T into attributes", src)
+}
+
+type Job struct {
+	Id           int64      `db:"id"`
+	Queue        string     `db:"queue"`
+	Body         []byte     `db:"body"`
+	Attributes   Attributes `db:"attributes"`
+	Receipt      *uuid.UUID `db:"receipt"`
+	ReceiveCount int        `db:"receive_count"`
+	VisibleAt    time.Time  `db:"visible_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+var ErrStaleReceipt = errors.New("job receipt is no longer valid")
+
+func (s *JobStore) Enqueue(ctx context.Context, queue string, body []byte, attributes Attributes, delay time.Duration) (*Job, error) {
+	const insert = `INSERT INTO jobs (queue, body, attributes, visible_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 millisecond') RETURNING *;`
+
+	var job Job
+	if err := s.db.GetContext(ctx, &job, insert, queue, body, attributes, delay.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job on %s: %w", queue, err)
+	}
+	return &job, nil
+}
+
+// Claim hands out up to limit visible jobs and hides them for the visibility
+// timeout. Concurrent claimers skip rows locked by each other, so a job is
+// delivered to a single consumer until its visibility runs out.
+func (s *JobStore) Claim(ctx context.Context, queue string, limit int, visibility time.Duration) ([]Job, error) {
+	const claim = `UPDATE jobs
+		SET receipt = gen_random_uuid(),
+			receive_count = receive_count + 1,
+			visible_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE queue = $1 AND visible_at <= CURRENT_TIMESTAMP
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) RETURNING *;`
+
+	var jobs []Job
+	if err := s.db.SelectContext(ctx, &jobs, claim, queue, limit, visibility.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("failed to claim jobs on %s: %w", queue, err)
+	}
+	return jobs, nil
+}
+
+func (s *JobStore) CountVisible(ctx context.Context, queue string) (int, error) {
+	const query = `SELECT COUNT(*) FROM jobs WHERE queue = $1 AND visible_at <= CURRENT_TIMESTAMP;`
+	var count int
+	if err := s.db.GetContext(ctx, &count, query, queue); err != nil {
+		return 0, fmt.Errorf("failed to count jobs on %s: %w", queue, err)
+	}
+	return count, nil
+}
+
+func (s *JobStore) Delete(ctx context.Context, id int64, receipt uuid.UUID) error {
+	const deleteStatement = `DELETE FROM jobs WHERE id = $1 AND receipt = $2;`
+	result, err := s.db.ExecContext(ctx, deleteStatement, id, receipt)
+	if err != nil {
+		return fmt.Errorf("failed to delete job %d: %w", id, err)
+	}
+	return checkReceipt(result, id)
+}
+
+// SetVisibility makes a claimed job visible again after delay. It is used both
+// to release a job early and to extend the visibility of a running one.
+func (s *JobStore) SetVisibility(ctx context.Context, id int64, receipt uuid.UUID, delay time.Duration) error {
+	const update = `UPDATE jobs
+		SET visible_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+		WHERE id = $1 AND receipt = $2;`
+	result, err := s.db.ExecContext(ctx, update, id, receipt, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to change visibility of job %d: %w", id, err)
+	}
+	return checkReceipt(result, id)
+}
+
+func checkReceipt(result sql.Result, id int64) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for job %d: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("job %d: %w", id, ErrStaleReceipt)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"context"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	jobStore := store.NewJobStore(env.DB)
+
+	job, err := jobStore.Enqueue(ctx, "reports", []byte(`{"reportId":"1"}`), store.Attributes{"type": "report"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, "reports", job.Queue)
+	require.Nil(t, job.Receipt)
+
+	count, err := jobStore.CountVisible(ctx, "reports")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	jobs, err := jobStore.Claim(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, job.Id, jobs[0].Id)
+	require.Equal(t, 1, jobs[0].ReceiveCount)
+	require.Equal(t, "report", jobs[0].Attributes["type"])
+	require.NotNil(t, jobs[0].Receipt)
+
+	// claimed jobs stay hidden until their visibility runs out
+	jobs2, err := jobStore.Claim(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, jobs2)
+	count, err = jobStore.CountVisible(ctx, "reports")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	require.NoError(t, jobStore.SetVisibility(ctx, job.Id, *jobs[0].Receipt, 0))
+	jobs2, err = jobStore.Claim(ctx, "reports", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs2, 1)
+	require.Equal(t, 2, jobs2[0].ReceiveCount)
+
+	require.ErrorIs(t, jobStore.Delete(ctx, job.Id, *jobs[0].Receipt), store.ErrStaleReceipt)
+	require.NoError(t, jobStore.Delete(ctx, job.Id, *jobs2[0].Receipt))
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type OutboxStore struct {
+	db *sqlx.DB
+}
+
+func NewOutboxStore(db *sql.DB) *OutboxStore {
+	return &OutboxStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// OutboxMessage is a queue message recorded in the same transaction as the
+// change that caused it. The relay publishes it afterwards, so a failing
+// queue can delay a message but never lose it. GroupId and DeduplicationId
+// are only used by FIFO queues.
+type OutboxMessage struct {
+	Id              int64      `db:"id"`
+	Queue           string     `db:"queue"`
+	Payload         []byte     `db:"payload"`
+	Attributes      Attributes `db:"attributes"`
+	GroupId         *string    `db:"group_id"`
+	DeduplicationId *string    `db:"deduplication_id"`
+	Attempts        int        `db:"attempts"`
+	LastError       *string    `db:"last_error"`
+	NextAttemptAt   time.Time  `db:"next_attempt_at"`
+	PublishedAt     *time.Time `db:"published_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+func insertOutboxMessage(ctx context.Context, db sqlx.QueryerContext, msg *OutboxMessage) (*OutboxMessage, error) {
+	const insert = `INSERT INTO outbox (queue, payload, attributes, group_id, deduplication_id)
+		VALUES ($1, $2, $3, $4, $5) RETURNING *;`
+
+	var outboxMessage OutboxMessage
+	if err := sqlx.GetContext(ctx, db, &outboxMessage, insert, msg.Queue, msg.Payload, msg.Attributes, msg.GroupId, msg.DeduplicationId); err != nil {
+		return nil, fmt.Errorf("failed to insert outbox message for %s: %w", msg.Queue, err)
+	}
+	return &outboxMessage, nil
+}
+
+func (s *OutboxStore) Create(ctx context.Context, msg *OutboxMessage) (*OutboxMessage, error) {
+	return insertOutboxMessage(ctx, s.db, msg)
+}
+
+// ClaimPending returns up to limit unpublished messages that are due and
+// pushes their next attempt out by lease, so concurrent relays do not publish
+// the same message while one of them is working on it.
+func (s *OutboxStore) ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	const claim = `UPDATE outbox
+		SET attempts = attempts + 1,
+			next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) RETURNING *;`
+
+	var messages []OutboxMessage
+	if err := s.db.SelectContext(ctx, &messages, claim, limit, lease.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *OutboxStore) MarkPublished(ctx context.Context, id int64) error {
+	const update = `UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = $1;`
+	if _, err := s.db.ExecContext(ctx, update, id); err != nil {
+		return fmt.Errorf("failed to mark outbox message %d as published: %w", id, err)
+	}
+	return nil
+}
+
+func (s *OutboxStore) MarkFailed(ctx context.Context, id int64, errMsg string, retryAfter time.Duration) error {
+	const update = `UPDATE outbox
+		SET last_error = $2,
+			next_attempt_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+		WHERE id = $1;`
+	if _, err := s.db.ExecContext(ctx, update, id, errMsg, retryAfter.Milliseconds()); err != nil {
+		return fmt.Errorf("failed to mark outbox message %d as failed: %w", id, err)
+	}
+	return nil
+}
+
+// DeletePublished removes up to limit messages published more than retention
+// ago. Unpublished messages are kept however old they are.
+func (s *OutboxStore) DeletePublished(ctx context.Context, retention time.Duration, limit int) (int64, error) {
+	const query = `DELETE FROM outbox WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NOT NULL
+				AND published_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 millisecond'
+			LIMIT $2
+		);`
+
+	result, err := s.db.ExecContext(ctx, query, retention.Milliseconds(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete published outbox messages: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,79 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	outboxStore := store.NewOutboxStore(env.DB)
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+	user, err := userStore.CreateUser(ctx, "test@test.com", "secretpassword")
+	require.NoError(t, err)
+
+	// a failing message builder rolls the report back
+	_, err = reportStore.CreateWithOutbox(ctx, user.Id, "monsters", func(report *store.Report) (*store.OutboxMessage, error) {
+		return nil, errors.New("boom")
+	})
+	require.Error(t, err)
+
+	report, err := reportStore.CreateWithOutbox(ctx, user.Id, "monsters", func(report *store.Report) (*store.OutboxMessage, error) {
+		return &store.OutboxMessage{Queue: "reports", Payload: []byte(report.Id.String())}, nil
+	})
+	require.NoError(t, err)
+
+	messages, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, "reports", messages[0].Queue)
+	require.Equal(t, report.Id.String(), string(messages[0].Payload))
+	require.Equal(t, 1, messages[0].Attempts)
+
+	// claimed messages are leased to a single relay
+	leased, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, leased)
+
+	require.NoError(t, outboxStore.MarkFailed(ctx, messages[0].Id, "queue unavailable", 0))
+	retried, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	require.Equal(t, 2, retried[0].Attempts)
+	require.Equal(t, "queue unavailable", *retried[0].LastError)
+
+	require.NoError(t, outboxStore.MarkPublished(ctx, retried[0].Id))
+	require.NoError(t, outboxStore.MarkFailed(ctx, retried[0].Id, "late failure", 0))
+	published, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, published)
+
+	// published messages are kept for the retention period
+	deleted, err := outboxStore.DeletePublished(ctx, time.Hour, 100)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	pending, err := outboxStore.Create(ctx, &store.OutboxMessage{Queue: "reports", Payload: []byte("pending")})
+	require.NoError(t, err)
+	deleted, err = outboxStore.DeletePublished(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	remaining, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, pending.Id, remaining[0].Id)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type RateLimitStore struct {
+	db *sqlx.DB
+}
+
+func NewRateLimitStore(db *sql.DB) *RateLimitStore {
+	return &RateLimitStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// UpdateBucket locks the token bucket of key, creating it full with capacity
+// tokens, and stores what update returns: the new token count and how long
+// until the bucket is full again. update gets the stored count and the time
+// since it was stored, measured by the database clock so api instances agree
+// on it.
+func (s *RateLimitStore) UpdateBucket(ctx context.Context, key string, capacity float64, update func(tokens float64, elapsed time.Duration) (float64, time.Duration)) error {
+	const insert = `INSERT INTO rate_limits (key, tokens) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING;`
+	const lock = `SELECT tokens, EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - updated_at) AS elapsed
+		FROM rate_limits WHERE key = $1 FOR UPDATE;`
+	const save = `UPDATE rate_limits
+		SET tokens = $2, updated_at = CURRENT_TIMESTAMP, full_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+		WHERE key = $1;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, insert, key, capacity); err != nil {
+		return fmt.Errorf("failed to create rate limit bucket %s: %w", key, err)
+	}
+
+	var bucket struct {
+		Tokens  float64 `db:"tokens"`
+		Elapsed float64 `db:"elapsed"`
+	}
+	if err := tx.GetContext(ctx, &bucket, lock, key); err != nil {
+		return fmt.Errorf("failed to lock rate limit bucket %s: %w", key, err)
+	}
+
+	tokens, fullIn := update(bucket.Tokens, time.Duration(bucket.Elapsed*float64(time.Second)))
+	if _, err := tx.ExecContext(ctx, save, key, tokens, fullIn.Milliseconds()); err != nil {
+		return fmt.Errorf("failed to update rate limit bucket %s: %w", key, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rate limit bucket %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteFull removes buckets that have refilled completely, which behave the
+// same as buckets that do not exist.
+func (s *RateLimitStore) DeleteFull(ctx context.Context) (int64, error) {
+	const query = `DELETE FROM rate_limits WHERE full_at <= CURRENT_TIMESTAMP;`
+
+	result, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete full rate limit buckets: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,40 @@
+package store_test
+
+import (
+	"context"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	rateLimitStore := store.NewRateLimitStore(env.DB)
+
+	// a new bucket starts full
+	require.NoError(t, rateLimitStore.UpdateBucket(ctx, "auth:ip:127.0.0.1", 5, func(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+		require.Equal(t, 5.0, tokens)
+		return tokens - 1, 0
+	}))
+	require.NoError(t, rateLimitStore.UpdateBucket(ctx, "auth:ip:127.0.0.1", 5, func(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+		require.Equal(t, 4.0, tokens)
+		require.GreaterOrEqual(t, elapsed, time.Duration(0))
+		return tokens - 1, time.Hour
+	}))
+	require.NoError(t, rateLimitStore.UpdateBucket(ctx, "read:ip:127.0.0.1", 5, func(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+		return tokens, 0
+	}))
+
+	deleted, err := rateLimitStore.DeleteFull(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-sqs/tracing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type ReportStatus string
+
+const (
+	ReportStatus_Requested  ReportStatus = "requested"
+	ReportStatus_Queued     ReportStatus = "queued"
+	ReportStatus_Processing ReportStatus = "processing"
+	ReportStatus_Completed  ReportStatus = "completed"
+	ReportStatus_Failed     ReportStatus = "failed"
+	ReportStatus_Cancelled  ReportStatus = "cancelled"
+	ReportStatus_Expired    ReportStatus = "expired"
+)
+
+var ErrInvalidTransition = errors.New("invalid report status transition")
+
+// reportTransitions lists the statuses a report may move to from each status.
+// A processing report moves to processing again when a worker reclaims it
+// after the previous lease expired.
+var reportTransitions = map[ReportStatus][]ReportStatus{
+	ReportStatus_Requested:  {ReportStatus_Queued, ReportStatus_Cancelled},
+	ReportStatus_Queued:     {ReportStatus_Processing, ReportStatus_Cancelled},
+	ReportStatus_Processing: {ReportStatus_Processing, ReportStatus_Completed, ReportStatus_Failed, ReportStatus_Queued},
+	ReportStatus_Failed:     {ReportStatus_Processing, ReportStatus_Queued},
+	ReportStatus_Completed:  {ReportStatus_Expired},
+}
+
+func (s ReportStatus) CanTransitionTo(to ReportStatus) bool {
+	for _, next := range reportTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionsInto returns the statuses a report may be in to move to status
+// to, ready to be passed as a postgres array.
+func transitionsInto(to ReportStatus) any {
+	var from []string
+	for status := range reportTransitions {
+		if status.CanTransitionTo(to) {
+			from = append(from, string(status))
+		}
+	}
+	return pq.Array(from)
+}
+
+// ReportEvent records one status change of a report. FromStatus is nil for
+// the event that created the report.
+type ReportEvent struct {
+	Id         int64         `db:"id"`
+	UserId     uuid.UUID     `db:"user_id"`
+	ReportId   uuid.UUID     `db:"report_id"`
+	FromStatus *ReportStatus `db:"from_status"`
+	ToStatus   ReportStatus  `db:"to_status"`
+	Actor      string        `db:"actor"`
+	Details    Attributes    `db:"details"`
+	CreatedAt  time.Time     `db:"created_at"`
+}
+
+func insertReportEvent(ctx context.Context, db sqlx.ExecerContext, event *ReportEvent) error {
+	const insert = `INSERT INTO report_events (user_id, report_id, from_status, to_status, actor, details)
+		VALUES ($1, $2, $3, $4, $5, $6);`
+
+	if event.FromStatus != nil && !event.FromStatus.CanTransitionTo(event.ToStatus) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, *event.FromStatus, event.ToStatus)
+	}
+	if _, err := db.ExecContext(ctx, insert,
+		event.UserId,
+		event.ReportId,
+		event.FromStatus,
+		event.ToStatus,
+		event.Actor,
+		event.Details); err != nil {
+		return fmt.Errorf("failed to insert event for report %s: %w", event.ReportId, err)
+	}
+	return nil
+}
+
+// transitionedReport is a report returned by an update that also selects the
+// status the report had before.
+type transitionedReport struct {
+	Report
+	PreviousStatus ReportStatus `db:"previous_status"`
+}
+
+// transitionReport runs update, which moves one report to status to and
+// returns it with its previous status, and records the transition in tx.
+func transitionReport(ctx context.Context, tx *sqlx.Tx, to ReportStatus, actor string, details Attributes, update string, args ...any) (*Report, error) {
+	var row transitionedReport
+	if err := tx.GetContext(ctx, &row, update, args...); err != nil {
+		return nil, err
+	}
+
+	if err := insertReportEvent(ctx, tx, &ReportEvent{
+		UserId:     row.UserID,
+		ReportId:   row.Id,
+		FromStatus: &row.PreviousStatus,
+		ToStatus:   to,
+		Actor:      actor,
+		Details:    details,
+	}); err != nil {
+		return nil, err
+	}
+	return &row.Report, nil
+}
+
+// Events returns the status history of a report, oldest first.
+func (s *ReportStore) Events(ctx context.Context, userId uuid.UUID, reportId uuid.UUID) (_ []ReportEvent, err error) {
+	ctx, span := startSpan(ctx, "Events")
+	defer tracing.EndSpan(span, &err)
+
+	const query = `SELECT * FROM report_events WHERE user_id = $1 AND report_id = $2 ORDER BY id;`
+
+	events := []ReportEvent{}
+	if err := s.db.SelectContext(ctx, &events, query, userId, reportId); err != nil {
+		return nil, fmt.Errorf("failed to query events of report %s: %w", reportId, err)
+	}
+	return events, nil
+}
@@ -3,14 +3,29 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go-sqs/tracing"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("go-sqs/store")
+
+// startSpan starts the span of a ReportStore query named after its method.
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "ReportStore."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")))
+}
+
 type ReportStore struct {
 	db *sqlx.DB
 }
@@ -22,74 +37,469 @@ func NewReportStore(db *sql.DB) *ReportStore {
 }
 
 type Report struct {
-	UserID         uuid.UUID  `db:"user_id"`
-	Id             uuid.UUID  `db:"id"`
-	ReportType     string     `db:"report_type"`
-	OutputFilePath *string    `db:"output_file_path"`
-	DownloadUrl    *string    `db:"download_url"`
-	ExpiresAt      *time.Time `db:"expires_at"`
-	ErrorMessage   *string    `db:"error_message"`
-	CreatedAt      time.Time  `db:"created_at"`
-	StartedAt      *time.Time `db:"started_at"`
-	FailedAt       *time.Time `db:"failed_at"`
-	CompletedAt    *time.Time `db:"completed_at"`
+	UserID         uuid.UUID    `db:"user_id"`
+	Id             uuid.UUID    `db:"id"`
+	ReportType     string       `db:"report_type"`
+	Status         ReportStatus `db:"status"`
+	OutputFilePath *string      `db:"output_file_path"`
+	DownloadUrl    *string      `db:"download_url"`
+	ExpiresAt      *time.Time   `db:"expires_at"`
+	ErrorMessage   *string      `db:"error_message"`
+	CreatedAt      time.Time    `db:"created_at"`
+	StartedAt      *time.Time   `db:"started_at"`
+	FailedAt       *time.Time   `db:"failed_at"`
+	CompletedAt    *time.Time   `db:"completed_at"`
+	WorkerId       *string      `db:"worker_id"`
+	LeaseExpiresAt *time.Time   `db:"lease_expires_at"`
+	Attempts       int          `db:"attempts"`
 }
 
+var (
+	// ErrReportNotClaimable is returned when a report is completed, cancelled
+	// or leased by another worker.
+	ErrReportNotClaimable = errors.New("report is not claimable")
+	ErrLeaseLost          = errors.New("report lease was lost")
+	// ErrReportNotCancellable is returned for reports a worker already
+	// started or finished.
+	ErrReportNotCancellable = errors.New("report can no longer be cancelled")
+)
+
 func (r *Report) IsDone() bool {
 	return r.FailedAt != nil || r.CompletedAt != nil
 }
 
-func (r *Report) Status() string {
-	switch {
-	case r.StartedAt == nil:
-		return "requested"
-	case r.StartedAt != nil && !r.IsDone():
-		return "processing"
-	case r.CompletedAt != nil:
-		return "completed"
-	case r.FailedAt != nil:
-		return "failed"
-	}
-	return "unknown"
+func UserActor(userId uuid.UUID) string {
+	return "user:" + userId.String()
+}
+
+func WorkerActor(workerId string) string {
+	return "worker:" + workerId
 }
 
-func (s *ReportStore) Create(ctx context.Context, userId uuid.UUID, reportType string) (*Report, error) {
-	const insert = `INSERT INTO reports (user_id, report_type) VALUES ($1, $2) RETURNING *;`
+// ReaperActor is the actor of transitions made for reports whose lease expired.
+const ReaperActor = "lease-reaper"
+
+// ExpiryActor is the actor of transitions made for reports past retention.
+const ExpiryActor = "report-expiry"
+
+func insertReport(ctx context.Context, tx *sqlx.Tx, userId uuid.UUID, reportType string, status ReportStatus) (*Report, error) {
+	const insert = `INSERT INTO reports (user_id, report_type, status) VALUES ($1, $2, $3) RETURNING *;`
 
 	var report Report
-	if err := s.db.GetContext(ctx, &report, insert, userId, reportType); err != nil {
+	if err := tx.GetContext(ctx, &report, insert, userId, reportType, status); err != nil {
 		return nil, fmt.Errorf("failed to insert report for user %s: %w", userId, err)
 	}
+	if err := insertReportEvent(ctx, tx, &ReportEvent{
+		UserId:   userId,
+		ReportId: report.Id,
+		ToStatus: ReportStatus_Requested,
+		Actor:    UserActor(userId),
+	}); err != nil {
+		return nil, err
+	}
 	return &report, nil
 }
 
-func (s *ReportStore) Update(ctx context.Context, report *Report) (*Report, error) {
+func (s *ReportStore) Create(ctx context.Context, userId uuid.UUID, reportType string) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "Create")
+	defer tracing.EndSpan(span, &err)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := insertReport(ctx, tx, userId, reportType, ReportStatus_Requested)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report %s: %w", report.Id, err)
+	}
+	return report, nil
+}
+
+// CreateWithOutbox inserts the report and the queue message built from it in
+// one transaction, so a report is never stored without its message. The
+// report starts out queued.
+func (s *ReportStore) CreateWithOutbox(ctx context.Context, userId uuid.UUID, reportType string, outbox func(*Report) (*OutboxMessage, error)) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "CreateWithOutbox")
+	defer tracing.EndSpan(span, &err)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := insertReport(ctx, tx, userId, reportType, ReportStatus_Queued)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := outbox(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build outbox message for report %s: %w", report.Id, err)
+	}
+
+	if _, err := insertOutboxMessage(ctx, tx, msg); err != nil {
+		return nil, err
+	}
+
+	requested := ReportStatus_Requested
+	if err := insertReportEvent(ctx, tx, &ReportEvent{
+		UserId:     userId,
+		ReportId:   report.Id,
+		FromStatus: &requested,
+		ToStatus:   ReportStatus_Queued,
+		Actor:      UserActor(userId),
+		Details:    Attributes{"queue": msg.Queue},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report %s: %w", report.Id, err)
+	}
+	return report, nil
+}
+
+// UpdateDownloadUrl stores a freshly signed download url for a completed
+// report, it returns sql.ErrNoRows once the report expired. Status changes go
+// through the transition methods instead, which validate and record them.
+func (s *ReportStore) UpdateDownloadUrl(ctx context.Context, userId uuid.UUID, id uuid.UUID, downloadUrl string, expiresAt time.Time) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "UpdateDownloadUrl")
+	defer tracing.EndSpan(span, &err)
+
+	const update = `UPDATE reports SET download_url = $3, expires_at = $4
+		WHERE user_id = $1 AND id = $2 AND status = 'completed' RETURNING *;`
+
+	var report Report
+	if err := s.db.GetContext(ctx, &report, update, userId, id, downloadUrl, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to update download url of report %s for user %s: %w", id, userId, err)
+	}
+	return &report, nil
+}
+
+// Claim atomically moves the report to processing for workerId. The lease
+// keeps other workers away until it expires, so a crashed worker's report can
+// be claimed again.
+func (s *ReportStore) Claim(ctx context.Context, userId uuid.UUID, id uuid.UUID, workerId string, lease time.Duration) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "Claim")
+	defer tracing.EndSpan(span, &err)
+
+	const claim = `WITH previous AS (
+			SELECT user_id, id, status FROM reports WHERE user_id = $1 AND id = $2 FOR UPDATE
+		)
+		UPDATE reports r
+		SET status = 'processing',
+			started_at = CURRENT_TIMESTAMP,
+			worker_id = $3,
+			lease_expires_at = CURRENT_TIMESTAMP + $4 * INTERVAL '1 millisecond',
+			attempts = r.attempts + 1,
+			failed_at = NULL,
+			error_message = NULL,
+			output_file_path = NULL,
+			download_url = NULL,
+			expires_at = NULL
+		FROM previous
+		WHERE r.user_id = previous.user_id AND r.id = previous.id
+			AND previous.status = ANY($5)
+			AND (r.lease_expires_at IS NULL OR r.lease_expires_at <= CURRENT_TIMESTAMP)
+		RETURNING r.*, previous.status AS previous_status;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := transitionReport(ctx, tx, ReportStatus_Processing, WorkerActor(workerId), nil, claim,
+		userId, id, workerId, lease.Milliseconds(), transitionsInto(ReportStatus_Processing))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("report %s for user %s: %w", id, userId, ErrReportNotClaimable)
+		}
+		return nil, fmt.Errorf("failed to claim report %s for user %s: %w", id, userId, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit claim of report %s: %w", id, err)
+	}
+	return report, nil
+}
+
+// RenewLease extends the lease workerId holds on the report. It returns
+// ErrLeaseLost once the lease expired and another worker claimed the report.
+func (s *ReportStore) RenewLease(ctx context.Context, userId uuid.UUID, id uuid.UUID, workerId string, lease time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "RenewLease")
+	defer tracing.EndSpan(span, &err)
+
 	const update = `UPDATE reports
-		SET output_file_path = $1,
-			download_url = $2,
-			expires_at = $3,
+		SET lease_expires_at = CURRENT_TIMESTAMP + $4 * INTERVAL '1 millisecond'
+		WHERE user_id = $1 AND id = $2 AND worker_id = $3 AND status = 'processing';`
+
+	result, err := s.db.ExecContext(ctx, update, userId, id, workerId, lease.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to renew lease on report %s: %w", id, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read rows affected for report %s: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("report %s: %w", id, ErrLeaseLost)
+	}
+	return nil
+}
+
+// Complete moves a report workerId is building to completed and releases the
+// lease. It returns ErrLeaseLost if workerId no longer holds the lease.
+func (s *ReportStore) Complete(ctx context.Context, userId uuid.UUID, id uuid.UUID, workerId string, outputFilePath string) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "Complete")
+	defer tracing.EndSpan(span, &err)
+
+	const complete = `WITH previous AS (
+			SELECT user_id, id, status FROM reports WHERE user_id = $1 AND id = $2 FOR UPDATE
+		)
+		UPDATE reports r
+		SET status = 'completed',
+			completed_at = CURRENT_TIMESTAMP,
+			output_file_path = $4,
+			worker_id = NULL,
+			lease_expires_at = NULL
+		FROM previous
+		WHERE r.user_id = previous.user_id AND r.id = previous.id
+			AND previous.status = 'processing' AND r.worker_id = $3
+		RETURNING r.*, previous.status AS previous_status;`
+
+	return s.finish(ctx, id, ReportStatus_Completed, workerId, nil, complete, userId, id, workerId, outputFilePath)
+}
+
+// Fail moves a report workerId is building to failed and releases the lease.
+// It returns ErrLeaseLost if workerId no longer holds the lease.
+func (s *ReportStore) Fail(ctx context.Context, userId uuid.UUID, id uuid.UUID, workerId string, errMsg string) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "Fail")
+	defer tracing.EndSpan(span, &err)
+
+	const fail = `WITH previous AS (
+			SELECT user_id, id, status FROM reports WHERE user_id = $1 AND id = $2 FOR UPDATE
+		)
+		UPDATE reports r
+		SET status = 'failed',
+			failed_at = CURRENT_TIMESTAMP,
 			error_message = $4,
-			started_at = $5,
-			completed_at = $6,
-			failed_at = $7
-		WHERE user_id = $8 AND id = $9 RETURNING *;`
-
-	if err := s.db.GetContext(ctx, &report, update,
-		report.OutputFilePath,
-		report.DownloadUrl,
-		report.ExpiresAt,
-		report.ErrorMessage,
-		report.StartedAt,
-		report.CompletedAt,
-		report.FailedAt,
-		report.UserID,
-		report.Id); err != nil {
-		return nil, fmt.Errorf("failed to update report %s for user %s: %w", report.Id, report.UserID, err)
+			worker_id = NULL,
+			lease_expires_at = NULL
+		FROM previous
+		WHERE r.user_id = previous.user_id AND r.id = previous.id
+			AND previous.status = 'processing' AND r.worker_id = $3
+		RETURNING r.*, previous.status AS previous_status;`
+
+	return s.finish(ctx, id, ReportStatus_Failed, workerId, Attributes{"error": errMsg}, fail, userId, id, workerId, errMsg)
+}
+
+func (s *ReportStore) finish(ctx context.Context, id uuid.UUID, to ReportStatus, workerId string, details Attributes, update string, args ...any) (*Report, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := transitionReport(ctx, tx, to, WorkerActor(workerId), details, update, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("report %s: %w", id, ErrLeaseLost)
+		}
+		return nil, fmt.Errorf("failed to mark report %s as %s: %w", id, to, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report %s as %s: %w", id, to, err)
 	}
 	return report, nil
 }
 
-func (s *ReportStore) ByPrimaryKey(ctx context.Context, userId uuid.UUID, id uuid.UUID) (*Report, error) {
+// RequeueExpired moves up to limit processing reports whose lease ran out
+// back to queued and writes a message for each to the outbox, in one
+// transaction, so they are built again.
+func (s *ReportStore) RequeueExpired(ctx context.Context, limit int, outbox func(*Report) (*OutboxMessage, error)) (_ []Report, err error) {
+	ctx, span := startSpan(ctx, "RequeueExpired")
+	defer tracing.EndSpan(span, &err)
+
+	const requeue = `UPDATE reports
+		SET status = 'queued', started_at = NULL, worker_id = NULL, lease_expires_at = NULL
+		WHERE (user_id, id) IN (
+			SELECT user_id, id FROM reports
+			WHERE status = 'processing' AND lease_expires_at <= CURRENT_TIMESTAMP
+			ORDER BY lease_expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) RETURNING *;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var reports []Report
+	if err := tx.SelectContext(ctx, &reports, requeue, limit); err != nil {
+		return nil, fmt.Errorf("failed to requeue expired reports: %w", err)
+	}
+
+	processing := ReportStatus_Processing
+	for i := range reports {
+		msg, err := outbox(&reports[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to build outbox message for report %s: %w", reports[i].Id, err)
+		}
+		if _, err := insertOutboxMessage(ctx, tx, msg); err != nil {
+			return nil, err
+		}
+		if err := insertReportEvent(ctx, tx, &ReportEvent{
+			UserId:     reports[i].UserID,
+			ReportId:   reports[i].Id,
+			FromStatus: &processing,
+			ToStatus:   ReportStatus_Queued,
+			Actor:      ReaperActor,
+			Details:    Attributes{"reason": "lease expired", "attempts": strconv.Itoa(reports[i].Attempts)},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit requeued reports: %w", err)
+	}
+	return reports, nil
+}
+
+// Cancel moves a report no worker has started yet to cancelled. Workers skip
+// cancelled reports, since they cannot claim them. It returns
+// ErrReportNotCancellable once the report is processing or done.
+func (s *ReportStore) Cancel(ctx context.Context, userId uuid.UUID, id uuid.UUID) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "Cancel")
+	defer tracing.EndSpan(span, &err)
+
+	const cancel = `WITH previous AS (
+			SELECT user_id, id, status FROM reports WHERE user_id = $1 AND id = $2 FOR UPDATE
+		)
+		UPDATE reports r
+		SET status = 'cancelled'
+		FROM previous
+		WHERE r.user_id = previous.user_id AND r.id = previous.id
+			AND previous.status = ANY($3)
+		RETURNING r.*, previous.status AS previous_status;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := transitionReport(ctx, tx, ReportStatus_Cancelled, UserActor(userId), nil, cancel,
+		userId, id, transitionsInto(ReportStatus_Cancelled))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("report %s for user %s: %w", id, userId, ErrReportNotCancellable)
+		}
+		return nil, fmt.Errorf("failed to cancel report %s for user %s: %w", id, userId, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit cancellation of report %s: %w", id, err)
+	}
+	return report, nil
+}
+
+// ExpireCompleted moves up to limit reports completed more than retention ago
+// to expired and drops their download urls. The output file path is kept
+// until ClearOutput, it marks the objects still to be deleted.
+func (s *ReportStore) ExpireCompleted(ctx context.Context, retention time.Duration, limit int) (_ []Report, err error) {
+	ctx, span := startSpan(ctx, "ExpireCompleted")
+	defer tracing.EndSpan(span, &err)
+
+	const expire = `UPDATE reports
+		SET status = 'expired', download_url = NULL, expires_at = NULL
+		WHERE (user_id, id) IN (
+			SELECT user_id, id FROM reports
+			WHERE status = 'completed'
+				AND completed_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 millisecond'
+			ORDER BY completed_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) RETURNING *;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var reports []Report
+	if err := tx.SelectContext(ctx, &reports, expire, retention.Milliseconds(), limit); err != nil {
+		return nil, fmt.Errorf("failed to expire completed reports: %w", err)
+	}
+
+	completed := ReportStatus_Completed
+	for i := range reports {
+		if err := insertReportEvent(ctx, tx, &ReportEvent{
+			UserId:     reports[i].UserID,
+			ReportId:   reports[i].Id,
+			FromStatus: &completed,
+			ToStatus:   ReportStatus_Expired,
+			Actor:      ExpiryActor,
+			Details:    Attributes{"retention": retention.String()},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit expired reports: %w", err)
+	}
+	return reports, nil
+}
+
+// ExpiredWithOutput returns up to limit expired reports whose objects have not
+// been deleted yet.
+func (s *ReportStore) ExpiredWithOutput(ctx context.Context, limit int) (_ []Report, err error) {
+	ctx, span := startSpan(ctx, "ExpiredWithOutput")
+	defer tracing.EndSpan(span, &err)
+
+	const query = `SELECT * FROM reports
+		WHERE status = 'expired' AND output_file_path IS NOT NULL
+		ORDER BY completed_at
+		LIMIT $1;`
+
+	var reports []Report
+	if err := s.db.SelectContext(ctx, &reports, query, limit); err != nil {
+		return nil, fmt.Errorf("failed to select expired reports: %w", err)
+	}
+	return reports, nil
+}
+
+// ClearOutput forgets the object of an expired report once it is deleted.
+func (s *ReportStore) ClearOutput(ctx context.Context, userId uuid.UUID, id uuid.UUID) (err error) {
+	ctx, span := startSpan(ctx, "ClearOutput")
+	defer tracing.EndSpan(span, &err)
+
+	const update = `UPDATE reports SET output_file_path = NULL
+		WHERE user_id = $1 AND id = $2 AND status = 'expired';`
+
+	if _, err := s.db.ExecContext(ctx, update, userId, id); err != nil {
+		return fmt.Errorf("failed to clear output of report %s for user %s: %w", id, userId, err)
+	}
+	return nil
+}
+
+func (s *ReportStore) ByPrimaryKey(ctx context.Context, userId uuid.UUID, id uuid.UUID) (_ *Report, err error) {
+	ctx, span := startSpan(ctx, "ByPrimaryKey")
+	defer tracing.EndSpan(span, &err)
+
 	const query = `SELECT * FROM reports WHERE user_id = $1 AND id = $2;`
 	var report Report
 	if err := s.db.GetContext(ctx, &report, query, userId, id); err != nil {
@@ -2,6 +2,7 @@ package store_test
 
 import (
 	"context"
+	"database/sql"
 	"go-sqs/fixtures"
 	"go-sqs/store"
 	"testing"
@@ -28,5 +29,158 @@ func TestReportStore(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, user.Id, report.UserID)
 	require.Equal(t, "monsters", report.ReportType)
+	require.Equal(t, store.ReportStatus_Requested, report.Status)
 	require.Less(t, now.UnixNano(), report.CreatedAt.UnixNano())
 }
+
+func TestReportLeases(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	outboxStore := store.NewOutboxStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+	user, err := userStore.CreateUser(ctx, "test@test.com", "secretpassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.CreateWithOutbox(ctx, user.Id, "monsters", func(report *store.Report) (*store.OutboxMessage, error) {
+		return &store.OutboxMessage{Queue: "reports", Payload: []byte(report.Id.String())}, nil
+	})
+	require.NoError(t, err)
+	require.Nil(t, report.StartedAt)
+	require.Equal(t, store.ReportStatus_Queued, report.Status)
+
+	claimed, err := reportStore.Claim(ctx, user.Id, report.Id, "worker-1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed.StartedAt)
+	require.Equal(t, "worker-1", *claimed.WorkerId)
+	require.Equal(t, 1, claimed.Attempts)
+
+	// a live lease keeps other workers away
+	_, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-2", time.Minute)
+	require.ErrorIs(t, err, store.ErrReportNotClaimable)
+	require.ErrorIs(t, reportStore.RenewLease(ctx, user.Id, report.Id, "worker-2", time.Minute), store.ErrLeaseLost)
+
+	// an expired lease is requeued through the outbox
+	require.NoError(t, reportStore.RenewLease(ctx, user.Id, report.Id, "worker-1", -time.Second))
+	requeued, err := reportStore.RequeueExpired(ctx, 10, func(report *store.Report) (*store.OutboxMessage, error) {
+		return &store.OutboxMessage{Queue: "reports", Payload: []byte(report.Id.String())}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, requeued, 1)
+	require.Nil(t, requeued[0].StartedAt)
+	require.Nil(t, requeued[0].WorkerId)
+
+	messages, err := outboxStore.ClaimPending(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.Equal(t, report.Id.String(), string(messages[1].Payload))
+
+	claimed, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-2", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 2, claimed.Attempts)
+	_, err = reportStore.Fail(ctx, user.Id, report.Id, "worker-1", "boom")
+	require.ErrorIs(t, err, store.ErrLeaseLost)
+	completed, err := reportStore.Complete(ctx, user.Id, report.Id, "worker-2", "/users/report.csv.gz")
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatus_Completed, completed.Status)
+	require.Nil(t, completed.WorkerId)
+
+	_, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-3", time.Minute)
+	require.ErrorIs(t, err, store.ErrReportNotClaimable)
+
+	events, err := reportStore.Events(ctx, user.Id, report.Id)
+	require.NoError(t, err)
+	statuses := make([]store.ReportStatus, 0, len(events))
+	for _, event := range events {
+		statuses = append(statuses, event.ToStatus)
+	}
+	require.Equal(t, []store.ReportStatus{
+		store.ReportStatus_Requested,
+		store.ReportStatus_Queued,
+		store.ReportStatus_Processing,
+		store.ReportStatus_Queued,
+		store.ReportStatus_Processing,
+		store.ReportStatus_Completed,
+	}, statuses)
+	require.Nil(t, events[0].FromStatus)
+	require.Equal(t, store.ReaperActor, events[3].Actor)
+	require.Equal(t, store.WorkerActor("worker-2"), events[5].Actor)
+
+	// completed reports can no longer be cancelled, and expire after retention
+	_, err = reportStore.Cancel(ctx, user.Id, report.Id)
+	require.ErrorIs(t, err, store.ErrReportNotCancellable)
+	expired, err := reportStore.ExpireCompleted(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Empty(t, expired)
+	expired, err = reportStore.ExpireCompleted(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, store.ReportStatus_Expired, expired[0].Status)
+	require.Equal(t, "/users/report.csv.gz", *expired[0].OutputFilePath)
+	require.Nil(t, expired[0].DownloadUrl)
+
+	// expired reports get no new download url, and keep their object path
+	// until the object is deleted
+	_, err = reportStore.UpdateDownloadUrl(ctx, user.Id, report.Id, "http://example.com/report", time.Now().Add(time.Minute))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	pending, err := reportStore.ExpiredWithOutput(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.NoError(t, reportStore.ClearOutput(ctx, user.Id, report.Id))
+	pending, err = reportStore.ExpiredWithOutput(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	events, err = reportStore.Events(ctx, user.Id, report.Id)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatus_Expired, events[len(events)-1].ToStatus)
+	require.Equal(t, store.ExpiryActor, events[len(events)-1].Actor)
+}
+
+func TestReportCancel(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+	user, err := userStore.CreateUser(ctx, "test@test.com", "secretpassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.CreateWithOutbox(ctx, user.Id, "monsters", func(report *store.Report) (*store.OutboxMessage, error) {
+		return &store.OutboxMessage{Queue: "reports", Payload: []byte(report.Id.String())}, nil
+	})
+	require.NoError(t, err)
+
+	cancelled, err := reportStore.Cancel(ctx, user.Id, report.Id)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatus_Cancelled, cancelled.Status)
+
+	// workers skip cancelled reports, and they stay cancelled
+	_, err = reportStore.Claim(ctx, user.Id, report.Id, "worker-1", time.Minute)
+	require.ErrorIs(t, err, store.ErrReportNotClaimable)
+	_, err = reportStore.Cancel(ctx, user.Id, report.Id)
+	require.ErrorIs(t, err, store.ErrReportNotCancellable)
+
+	events, err := reportStore.Events(ctx, user.Id, report.Id)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatus_Cancelled, events[len(events)-1].ToStatus)
+	require.Equal(t, store.UserActor(user.Id), events[len(events)-1].Actor)
+}
+
+func TestReportStatusTransitions(t *testing.T) {
+	require.True(t, store.ReportStatus_Queued.CanTransitionTo(store.ReportStatus_Processing))
+	require.True(t, store.ReportStatus_Failed.CanTransitionTo(store.ReportStatus_Processing))
+	require.True(t, store.ReportStatus_Completed.CanTransitionTo(store.ReportStatus_Expired))
+	require.False(t, store.ReportStatus_Completed.CanTransitionTo(store.ReportStatus_Processing))
+	require.False(t, store.ReportStatus_Cancelled.CanTransitionTo(store.ReportStatus_Queued))
+	require.False(t, store.ReportStatus_Requested.CanTransitionTo(store.ReportStatus_Completed))
+}
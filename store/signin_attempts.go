@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type SigninAttemptStore struct {
+	db *sqlx.DB
+}
+
+func NewSigninAttemptStore(db *sql.DB) *SigninAttemptStore {
+	return &SigninAttemptStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type SigninOutcome string
+
+const (
+	SigninOutcome_Succeeded SigninOutcome = "succeeded"
+	SigninOutcome_Failed    SigninOutcome = "failed"
+	// SigninOutcome_Throttled and SigninOutcome_Locked are attempts rejected
+	// without checking the password.
+	SigninOutcome_Throttled SigninOutcome = "throttled"
+	SigninOutcome_Locked    SigninOutcome = "locked"
+)
+
+// SigninAttempt is recorded for every signin, whether or not the email
+// belongs to an account. UserId is only set for existing accounts.
+type SigninAttempt struct {
+	Id        int64         `db:"id"`
+	Email     string        `db:"email"`
+	Ip        string        `db:"ip"`
+	UserId    *uuid.UUID    `db:"user_id"`
+	Outcome   SigninOutcome `db:"outcome"`
+	CreatedAt time.Time     `db:"created_at"`
+}
+
+// SigninFailures counts recent failed attempts for an email, since its last
+// successful signin, and from an ip across all emails.
+type SigninFailures struct {
+	Email            int        `db:"email_failures"`
+	LastEmailFailure *time.Time `db:"last_email_failure"`
+	Ip               int        `db:"ip_failures"`
+	LastIpFailure    *time.Time `db:"last_ip_failure"`
+}
+
+func (s *SigninAttemptStore) Record(ctx context.Context, attempt *SigninAttempt) error {
+	return recordSigninAttempt(ctx, s.db, attempt)
+}
+
+// Failures counts the failed attempts for email and from ip within window.
+// A successful signin resets the count of the email but not of the ip, so
+// signing in to one account does not clear guesses against others.
+func (s *SigninAttemptStore) Failures(ctx context.Context, email string, ip string, window time.Duration) (*SigninFailures, error) {
+	return countSigninFailures(ctx, s.db, email, ip, window)
+}
+
+// Lock starts a transaction holding a lock on the attempts of email until it
+// is committed or rolled back. Concurrent signins for one email then count
+// failures and record their outcome one after the other, so they cannot all
+// get past the lockout on the same count.
+func (s *SigninAttemptStore) Lock(ctx context.Context, email string) (*SigninAttemptTx, error) {
+	const lock = `SELECT pg_advisory_xact_lock(hashtext('signin_attempts:' || $1));`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, lock, email); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to lock signin attempts: %w", err)
+	}
+	return &SigninAttemptTx{tx: tx}, nil
+}
+
+// DeleteBefore removes attempts older than age, which no longer count
+// towards any delay or lockout.
+func (s *SigninAttemptStore) DeleteBefore(ctx context.Context, age time.Duration) (int64, error) {
+	const query = `DELETE FROM signin_attempts WHERE created_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 millisecond';`
+
+	result, err := s.db.ExecContext(ctx, query, age.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old signin attempts: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+	return deleted, nil
+}
+
+// SigninAttemptTx reads and records the attempts of one email under the lock
+// taken by SigninAttemptStore.Lock.
+type SigninAttemptTx struct {
+	tx *sqlx.Tx
+}
+
+func (t *SigninAttemptTx) Failures(ctx context.Context, email string, ip string, window time.Duration) (*SigninFailures, error) {
+	return countSigninFailures(ctx, t.tx, email, ip, window)
+}
+
+func (t *SigninAttemptTx) Record(ctx context.Context, attempt *SigninAttempt) error {
+	return recordSigninAttempt(ctx, t.tx, attempt)
+}
+
+func (t *SigninAttemptTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit signin attempt: %w", err)
+	}
+	return nil
+}
+
+// Rollback releases the lock without recording anything. It does nothing
+// after Commit, so it can be deferred.
+func (t *SigninAttemptTx) Rollback() {
+	t.tx.Rollback()
+}
+
+func recordSigninAttempt(ctx context.Context, db sqlx.ExtContext, attempt *SigninAttempt) error {
+	const insert = `INSERT INTO signin_attempts (email, ip, user_id, outcome) VALUES ($1, $2, $3, $4);`
+
+	if _, err := db.ExecContext(ctx, insert, attempt.Email, attempt.Ip, attempt.UserId, attempt.Outcome); err != nil {
+		return fmt.Errorf("failed to record %s signin attempt: %w", attempt.Outcome, err)
+	}
+	return nil
+}
+
+func countSigninFailures(ctx context.Context, db sqlx.ExtContext, email string, ip string, window time.Duration) (*SigninFailures, error) {
+	const query = `WITH recent AS (
+			SELECT * FROM signin_attempts
+			WHERE (email = $1 OR ip = $2) AND created_at > CURRENT_TIMESTAMP - $3 * INTERVAL '1 millisecond'
+		), reset AS (
+			SELECT MAX(created_at) AS at FROM recent WHERE email = $1 AND outcome = 'succeeded'
+		)
+		SELECT
+			COUNT(*) FILTER (WHERE email = $1 AND (reset.at IS NULL OR created_at > reset.at)) AS email_failures,
+			MAX(created_at) FILTER (WHERE email = $1 AND (reset.at IS NULL OR created_at > reset.at)) AS last_email_failure,
+			COUNT(*) FILTER (WHERE ip = $2) AS ip_failures,
+			MAX(created_at) FILTER (WHERE ip = $2) AS last_ip_failure
+		FROM recent, reset
+		WHERE outcome = 'failed';`
+
+	var failures SigninFailures
+	if err := sqlx.GetContext(ctx, db, &failures, query, email, ip, window.Milliseconds()); err != nil {
+		return nil, fmt.Errorf("failed to count signin failures: %w", err)
+	}
+	return &failures, nil
+}
@@ -0,0 +1,78 @@
+package store_test
+
+import (
+	"context"
+	"go-sqs/fixtures"
+	"go-sqs/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigninAttemptStore(t *testing.T) {
+	env := fixtures.NewTestEnv(t)
+	cleanup := env.SetupDb(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	attemptStore := store.NewSigninAttemptStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+	user, err := userStore.CreateUser(ctx, "test@test.com", "secretpassword")
+	require.NoError(t, err)
+
+	record := func(email string, ip string, outcome store.SigninOutcome) {
+		require.NoError(t, attemptStore.Record(ctx, &store.SigninAttempt{Email: email, Ip: ip, Outcome: outcome}))
+	}
+	record("test@test.com", "10.0.0.1", store.SigninOutcome_Failed)
+	record("test@test.com", "10.0.0.1", store.SigninOutcome_Failed)
+	record("unknown@test.com", "10.0.0.1", store.SigninOutcome_Failed)
+	record("test@test.com", "10.0.0.2", store.SigninOutcome_Throttled)
+
+	failures, err := attemptStore.Failures(ctx, "test@test.com", "10.0.0.1", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 2, failures.Email)
+	require.Equal(t, 3, failures.Ip)
+	require.NotNil(t, failures.LastEmailFailure)
+
+	// a successful signin resets the email but not the ip
+	require.NoError(t, attemptStore.Record(ctx, &store.SigninAttempt{Email: "test@test.com", Ip: "10.0.0.1", UserId: &user.Id, Outcome: store.SigninOutcome_Succeeded}))
+	failures, err = attemptStore.Failures(ctx, "test@test.com", "10.0.0.1", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, failures.Email)
+	require.Nil(t, failures.LastEmailFailure)
+	require.Equal(t, 3, failures.Ip)
+
+	// a second signin for the email waits until the first has recorded its
+	// outcome, then counts it
+	locked, err := attemptStore.Lock(ctx, "test@test.com")
+	require.NoError(t, err)
+	counted := make(chan int)
+	go func() {
+		waiting, err := attemptStore.Lock(ctx, "test@test.com")
+		if err != nil {
+			counted <- -1
+			return
+		}
+		defer waiting.Rollback()
+		failures, err := waiting.Failures(ctx, "test@test.com", "10.0.0.1", time.Hour)
+		if err != nil {
+			counted <- -1
+			return
+		}
+		counted <- failures.Email
+	}()
+	require.NoError(t, locked.Record(ctx, &store.SigninAttempt{Email: "test@test.com", Ip: "10.0.0.1", Outcome: store.SigninOutcome_Failed}))
+	require.NoError(t, locked.Commit())
+	require.Equal(t, 1, <-counted)
+
+	// attempts past retention are pruned
+	deleted, err := attemptStore.DeleteBefore(ctx, time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	deleted, err = attemptStore.DeleteBefore(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), deleted)
+}
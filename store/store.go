@@ -6,6 +6,12 @@ type Store struct {
 	Users *UserStore
 	RefreshTokens *RefreshTokenStore
 	ReportStore *ReportStore
+	Jobs *JobStore
+	Outbox *OutboxStore
+	DeadLetters *DeadLetterStore
+	RateLimits *RateLimitStore
+	SigninAttempts *SigninAttemptStore
+	EmailVerifications *EmailVerificationStore
 }
 
 func New(db *sql.DB) *Store {
@@ -13,5 +19,11 @@ func New(db *sql.DB) *Store {
 		Users: NewUserStore(db),
 		RefreshTokens: NewRefreshTokenStore(db),
 		ReportStore: NewReportStore(db),
+		Jobs: NewJobStore(db),
+		Outbox: NewOutboxStore(db),
+		DeadLetters: NewDeadLetterStore(db),
+		RateLimits: NewRateLimitStore(db),
+		SigninAttempts: NewSigninAttemptStore(db),
+		EmailVerifications: NewEmailVerificationStore(db),
 	}
 }
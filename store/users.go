@@ -4,14 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserExists is returned when an account already uses the email, compared
+// case insensitively.
+var ErrUserExists = errors.New("user already exists")
+
 type UserStore struct {
 	db *sqlx.DB
 }
@@ -27,6 +32,13 @@ type User struct {
 	Email                string    `db:"email"`
 	HashedPasswordBase64 string    `db:"hashed_password"`
 	CreatedAt            time.Time `db:"created_at"`
+	IsAdmin              bool      `db:"is_admin"`
+	// EmailVerifiedAt is nil until the user confirms their email address
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 func (u *User) ComparePassword(password string) error {
@@ -55,6 +67,10 @@ func (s *UserStore) CreateUser(ctx context.Context, email, password string) (*Us
 	hashedPasswordBase64 := base64.StdEncoding.EncodeToString(bytes)
 
 	if err := s.db.GetContext(ctx, &user, dml, email, hashedPasswordBase64); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrUserExists
+		}
 		return nil, err
 	}
 
@@ -62,7 +78,7 @@ func (s *UserStore) CreateUser(ctx context.Context, email, password string) (*Us
 }
 
 func (s *UserStore) ByEmail(ctx context.Context, email string) (*User, error) {
-	const query = `SELECT * FROM users where email = $1;`
+	const query = `SELECT * FROM users where lower(email) = lower($1);`
 	var user User
 	if err := s.db.GetContext(ctx, &user, query, email); err != nil {
 		return nil, err
@@ -34,4 +34,11 @@ func TestUserStore(t *testing.T) {
 	require.Equal(t, user.Email, user2.Email)
 	require.Equal(t, user.Id, user2.Id)
 	require.Equal(t, user.HashedPasswordBase64, user2.HashedPasswordBase64)
+
+	user3, err := userStore.ByEmail(ctx, "Test@TEST.com")
+	require.NoError(t, err)
+	require.Equal(t, user.Id, user3.Id)
+
+	_, err = userStore.CreateUser(ctx, "TEST@test.com", "otherpassword")
+	require.ErrorIs(t, err, store.ErrUserExists)
 }
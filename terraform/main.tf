@@ -49,4 +49,6 @@ resource "aws_sqs_queue" "reports-s3-queue" {
   max_message_size          = 2048
   message_retention_seconds = 86400
   receive_wait_time_seconds = 10
+  # a queue name ending in .fifo switches the api and worker to FIFO mode
+  fifo_queue                = endswith(var.sqs_queue, ".fifo")
 }
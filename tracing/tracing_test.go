@@ -0,0 +1,34 @@
+package tracing_test
+
+import (
+	"context"
+	"go-sqs/config"
+	"go-sqs/tracing"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{
+		TracesExporter: string(tracing.Exporter_File),
+		TracesFile:     filepath.Join(t.TempDir(), "traces.jsonl"),
+	}
+
+	shutdown, err := tracing.Setup(ctx, conf, "test")
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(ctx, "build report")
+	span.End()
+	require.NoError(t, shutdown(ctx))
+
+	traces, err := os.ReadFile(conf.TracesFile)
+	require.NoError(t, err)
+	require.Contains(t, string(traces), "build report")
+
+	_, err = tracing.Setup(ctx, &config.Config{TracesExporter: "zipkin"}, "test")
+	require.Error(t, err)
+}